@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -15,12 +16,14 @@ import (
 
 var stdout *bufio.Writer // global only because os.Exit doesn't run defers
 
+var useNumber bool // decode numbers as json.Number, preserving their text
+
 func main() {
 	byLine := flag.Bool("l", false, "one JSON value per line, and result set on single line")
-	//	useNumber := flag.Bool("n", false, "represent JSON numbers as integer, floating-point or string")
+	flag.BoolVar(&useNumber, "n", false, "represent JSON numbers exactly, as integer, floating-point or string")
 	flag.Parse()
 	if flag.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "usage: jpath [-l] pat [file ...]\n")
+		fmt.Fprintf(os.Stderr, "usage: jpath [-l] [-n] pat [file ...]\n")
 		os.Exit(2)
 	}
 	stdout = bufio.NewWriter(os.Stdout)
@@ -28,6 +31,10 @@ func main() {
 	jexp := flag.Arg(0)
 	jpath, err := jsonpath.Compile(jexp)
 	if err != nil {
+		var se *jsonpath.SyntaxError
+		if errors.As(err, &se) {
+			errorf("path %s: %s\n%s", quote(jexp), err.Error(), se.Caret())
+		}
 		errorf("path %s: %s", quote(jexp), err.Error())
 	}
 	var reader func(*os.File, *jsonpath.JSONPath, *json.Encoder) error
@@ -69,7 +76,9 @@ func quote(s string) string {
 // readValues runs the JSONPath machine against a sequence of JSON values, across newlines, producing results as formatted JSON.
 func readValues(fd *os.File, jpath *jsonpath.JSONPath, enc *json.Encoder) error {
 	dec := json.NewDecoder(fd)
-	// dec.UseNumber()
+	if useNumber {
+		dec.UseNumber()
+	}
 	for {
 		var root interface{}
 		off := dec.InputOffset()
@@ -98,7 +107,14 @@ func readLines(fd *os.File, jpath *jsonpath.JSONPath, enc *json.Encoder) error {
 	input.Split(bufio.ScanLines)
 	for lno := 1; input.Scan(); lno++ {
 		var root interface{}
-		err := json.Unmarshal(input.Bytes(), &root)
+		var err error
+		if useNumber {
+			dec := json.NewDecoder(bytes.NewReader(input.Bytes()))
+			dec.UseNumber()
+			err = dec.Decode(&root)
+		} else {
+			err = json.Unmarshal(input.Bytes(), &root)
+		}
 		if err != nil {
 			return fmt.Errorf("%d: decoding JSON: %w", lno, err)
 		}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/forsyth/jsonpath"
 )
@@ -89,3 +91,111 @@ func Example() {
 	// 	]} ->
 	// 	 ["Decline and Fall","Wealth of Nations"]
 }
+
+func ExampleJSONPath_EvalNodes() {
+	var doc interface{}
+	err := json.Unmarshal([]byte(docs[0]), &doc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+		return
+	}
+	jpath := jsonpath.MustCompile("$.books[?(@.date < 1900)].title")
+	nodes, err := jpath.EvalNodes(doc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+		return
+	}
+	for _, n := range nodes {
+		fmt.Printf("%s: %v\n", n.Path, n.Value)
+	}
+	// Output:
+	// $['books'][1]['title']: Wealth of Nations
+}
+
+func ExampleWithFunctions() {
+	var doc interface{}
+	err := json.Unmarshal([]byte(docs[0]), &doc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+		return
+	}
+	// age returns the age in years of a date in 2000
+	age := jsonpath.NewFunction(1, func(args []interface{}) interface{} {
+		date, ok := args[0].(float64)
+		if !ok {
+			return nil
+		}
+		return 2000 - date
+	})
+	jpath, err := jsonpath.Compile("$.books[?(age(@.date) > 200)].title", jsonpath.WithFunctions(map[string]jsonpath.Function{"age": age}))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+		return
+	}
+	vals, err := jpath.Eval(doc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+		return
+	}
+	fmt.Println(vals)
+	// Output:
+	// [Wealth of Nations]
+}
+
+func ExampleJSONPath_EvalStream() {
+	jpath := jsonpath.MustCompile("$.books[?(@.date < 1900)].title")
+	err := jpath.EvalStream(strings.NewReader(docs[0]), func(v interface{}) error {
+		fmt.Println(v)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+	}
+	err = jsonpath.MustCompile("$.books[?(@.date < $.limit)]").EvalStream(strings.NewReader(docs[0]), nil)
+	fmt.Println(err)
+	// Output:
+	// Wealth of Nations
+	// path cannot be evaluated on a stream: filter refers to $, which needs the whole document
+}
+
+func ExampleDecodeOrdered() {
+	const doc = `{"title": "Decline and Fall", "author": "Evelyn Waugh", "date": 1928}`
+	jpath := jsonpath.MustCompile("$.*")
+	root, order, err := jsonpath.DecodeOrdered(json.NewDecoder(strings.NewReader(doc)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+		return
+	}
+	sorted, _ := jpath.Eval(root)
+	fmt.Println(sorted)
+	inDoc, _ := jpath.InKeyOrder(order).Eval(root)
+	fmt.Println(inDoc)
+	// Output:
+	// [Evelyn Waugh 1928 Decline and Fall]
+	// [Decline and Fall Evelyn Waugh 1928]
+}
+
+func ExampleWithClock() {
+	const doc = `{"events": [
+		{"name": "launch", "at": "2024-02-28T09:30:00Z"},
+		{"name": "review", "at": "2024-02-29T16:00:00+01:00"}
+	]}`
+	var root interface{}
+	err := json.Unmarshal([]byte(doc), &root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+		return
+	}
+	clock := func() time.Time {
+		return time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	}
+	jpath := jsonpath.MustCompile("$.events[?(parse_time(@.at) > now() - 86400000)].name", jsonpath.WithClock(clock))
+	vals, err := jpath.Eval(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "example: %s\n", err)
+		return
+	}
+	fmt.Println(vals)
+	// Output:
+	// [review]
+}
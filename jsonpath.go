@@ -18,21 +18,44 @@
 // A given JSONpath expression (in textual form) is first transformed by Compile or MustCompile to
 // a pointer to a JSONPath value that can then be applied repeatedly to JSON values using its Eval method.
 // Compilation checks that the expression is valid JSONpath syntax as defined above.
+// For large documents, EvalStream instead applies a path to a document as it is read,
+// decoding only the parts of it that the path might select.
+//
+// Options to Compile can change the defaults. In particular, the RFC9535 option selects the syntax and semantics of
+// RFC 9535 instead of the default dialect, for queries that must behave the same way as other implementations of the RFC.
 package jsonpath
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/forsyth/jsonpath/mach"
 	"github.com/forsyth/jsonpath/paths"
 )
 
+// Node is a value selected by a path, with its location in the document,
+// both as an RFC 9535 normalized path (eg, $['store']['book'][0]) and as a sequence of PathElements.
+type Node = mach.Node
+
+// PathElement is one step in the location of a Node: an object member name, or an array index.
+type PathElement = mach.PathElement
+
 // JSONPath represents a compiled  JSONpath expression.
 // It is safe for concurrent use by goroutines.
 type JSONPath struct {
 	expr string        // as passed to Compile
 	path *paths.Path   // the parsed expression
 	prog *mach.Program // the program for the abstract machine
+	opts []mach.Option // options for the machine, from Compile
+
+	streamOnce sync.Once    // compile stream when first needed
+	stream     *mach.Stream // evaluator for EvalStream
+	streamErr  error        // reason path cannot be streamed
 }
 
 // String returns the source text used to compile the JSONpath expression.
@@ -40,24 +63,189 @@ func (path *JSONPath) String() string {
 	return path.expr
 }
 
+// An Option changes a default setting of Compile.
+type Option interface {
+	apply(*config)
+}
+
+// optionFunc is an Option that changes the settings directly.
+type optionFunc func(*config)
+
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// config collects the settings from a list of Options.
+type config struct {
+	dialect   paths.Dialect
+	functions map[string]Function
+	keyOrder  KeyOrder
+	regexps   *int             // size of the cache of dynamic regular expressions, if set
+	clock     func() time.Time // source of the time returned by now(), if set
+}
+
+// machOptions returns the options for the abstract machine that correspond to the settings in c.
+func (c *config) machOptions() []mach.Option {
+	opts := []mach.Option{mach.WithDialect(c.dialect)}
+	if c.functions != nil {
+		opts = append(opts, mach.WithFunctions(c.functions))
+	}
+	if c.keyOrder != nil {
+		opts = append(opts, mach.WithKeyOrder(c.keyOrder))
+	}
+	if c.regexps != nil {
+		opts = append(opts, mach.WithRegexpCache(*c.regexps))
+	}
+	if c.clock != nil {
+		opts = append(opts, mach.WithClock(c.clock))
+	}
+	return opts
+}
+
+// Function is a function that can be called from filter and script expressions.
+type Function = mach.Function
+
+// AnyNumber as the argument count of a Function means it accepts any number of arguments.
+const AnyNumber = mach.AnyNumber
+
+// NewFunction returns a Function that takes na arguments (or AnyNumber), with body fn.
+// The body is given the argument values, and returns the result, which should be a JSON value.
+// If the function's result is an error value, the call yields nothing, as when a built-in function
+// is given an argument of the wrong type.
+func NewFunction(na int, fn func(args []interface{}) interface{}) Function {
+	return mach.NewFunction(na, fn)
+}
+
+// RegisterFunction makes fn available under the given name to all paths compiled subsequently,
+// replacing any predefined function with that name.
+// It is typically called during initialisation.
+func RegisterFunction(name string, fn Function) {
+	mach.Register(name, fn)
+}
+
+// WithFunctions returns an Option that makes the given functions available to the path being compiled,
+// in addition to the predefined ones (which they replace if they have the same name).
+// Calls of unknown functions, or with the wrong number of arguments, are rejected by Compile.
+// The RFC9535 dialect allows only the function extensions defined by the RFC, so other functions
+// can be called only by paths in the default dialect.
+func WithFunctions(fns map[string]Function) Option {
+	return optionFunc(func(c *config) {
+		if c.functions == nil {
+			c.functions = make(map[string]Function)
+		}
+		for name, fn := range fns {
+			c.functions[name] = fn
+		}
+	})
+}
+
+// KeyOrder determines the order in which wildcards, filters, ".." and functions such as keys visit the members of an object.
+type KeyOrder = mach.KeyOrder
+
+var (
+	// SortedKeys visits object members in order of their names. It is the default, making results repeatable.
+	SortedKeys = mach.SortedKeys
+
+	// MapOrder visits object members in Go's map iteration order, which varies from run to run, but avoids sorting.
+	MapOrder = mach.MapOrder
+)
+
+// WithKeyOrder returns an Option that sets the order in which object members are visited.
+func WithKeyOrder(order KeyOrder) Option {
+	return optionFunc(func(c *config) {
+		c.keyOrder = order
+	})
+}
+
+// DefaultRegexpCacheSize is the number of dynamic regular expressions a compiled path keeps, unless WithRegexpCache says otherwise.
+const DefaultRegexpCacheSize = mach.DefaultRegexpCacheSize
+
+// WithRegexpCache returns an Option that sets how many dynamic regular expressions the compiled path keeps compiled:
+// those given as strings, as in @.name =~ $.pattern or tokenize(@.text, @.sep), which would otherwise be compiled
+// again for each value tested. The cache is shared by all evaluations of the path, including concurrent ones.
+// A size of zero or less turns the cache off.
+func WithRegexpCache(size int) Option {
+	return optionFunc(func(c *config) {
+		c.regexps = &size
+	})
+}
+
+// WithClock returns an Option that sets the source of the current time returned by the function now()
+// (by default, time.Now), for instance to make the results of paths that use it repeatable in tests.
+func WithClock(clock func() time.Time) Option {
+	return optionFunc(func(c *config) {
+		c.clock = clock
+	})
+}
+
+// DocumentOrder is a KeyOrder that visits object members in the order they appear in a document decoded by DecodeOrdered.
+type DocumentOrder = mach.DocumentOrder
+
+// DecodeOrdered reads the next JSON value from dec, as dec.Decode would (respecting UseNumber, for instance),
+// and also returns the order of the members of its objects, for use with InKeyOrder.
+func DecodeOrdered(dec *json.Decoder) (interface{}, *DocumentOrder, error) {
+	return mach.DecodeOrdered(dec)
+}
+
+// Dialect is an Option that selects the syntax and semantics of the path language.
+type Dialect int
+
+const (
+	// Parker is the default dialect, described by jsonpath/syntax, with JavaScript's rules for expressions.
+	Parker = Dialect(paths.Parker)
+
+	// RFC9535 selects the grammar and semantics of RFC 9535.
+	// Filters are ?logical-expr without the need for parentheses, there are no script (expr) selectors,
+	// and filter expressions contain only comparisons, logical operators, singular queries and the RFC's function extensions
+	// (length, count, match and search with I-Regexp patterns, and value).
+	// Comparisons follow the RFC instead of JavaScript's abstract equality:
+	// values of different types are never equal, and only numbers and strings are ordered.
+	RFC9535 = Dialect(paths.RFC9535)
+)
+
+func (d Dialect) apply(c *config) {
+	c.dialect = paths.Dialect(d)
+}
+
+// String returns the name of the dialect.
+func (d Dialect) String() string {
+	return paths.Dialect(d).String()
+}
+
+// SyntaxError is returned by Compile for an invalid expression, locating the error within it.
+// Its Caret method displays the line of the expression in error, marking where.
+type SyntaxError = paths.SyntaxError
+
 // Compile parses a JSONpath expression and, if it is syntactically valid, returns a JSONPath value
 // that allows repeated evaluation of that expression against a given JSON value.
-// If the expression is not valid JSONPath, Compile instead returns only an error.
-func Compile(expr string) (*JSONPath, error) {
-	path, err := paths.ParsePath(expr)
+// If the expression is not valid JSONPath, or calls an unknown function or one with the wrong number of arguments,
+// Compile instead returns only an error, which is a *SyntaxError giving the location of the problem in expr.
+// The only other error is mach.ErrTooManyVals, for an expression too large to compile.
+// Options, if any, change the defaults (eg, the dialect).
+func Compile(expr string, opts ...Option) (*JSONPath, error) {
+	var c config
+	for _, opt := range opts {
+		opt.apply(&c)
+	}
+	path, err := paths.ParsePathDialect(expr, c.dialect)
 	if err != nil {
 		return nil, err
 	}
-	prog, err := mach.Compile(path)
+	mopts := c.machOptions()
+	prog, err := mach.Compile(path, mopts...)
 	if err != nil {
+		var ce *mach.CallError
+		if errors.As(err, &ce) {
+			return nil, paths.ErrorAt(expr, ce.Offset, ce.Err)
+		}
 		return nil, err
 	}
-	return &JSONPath{expr: expr, prog: prog}, nil
+	return &JSONPath{expr: expr, path: &path, prog: prog, opts: mopts}, nil
 }
 
 // MustCompile is like Compile but panics if the expression is invalid.
-func MustCompile(expr string) *JSONPath {
-	p, err := Compile(expr)
+func MustCompile(expr string, opts ...Option) *JSONPath {
+	p, err := Compile(expr, opts...)
 	if err != nil {
 		panic(`jsonpath: Compile(` + quote(expr) + `): ` + err.Error())
 	}
@@ -73,6 +261,8 @@ func quote(s string) string {
 
 // Eval evaluates a previously-compiled JSONpath expression against a given JSON value
 // (the root of a document), as returned by encoding/json.Decoder.Unmarshal.
+// If the Decoder's UseNumber option was set, the resulting json.Number values are treated as numbers throughout,
+// and are compared exactly even when they do not fit in a float64; selected values are returned unchanged.
 // It returns a slice containing the list of JSON values selected by the path expression.
 // If a run-time error occurs, for instance an invalid dynamic regular expression,
 // Eval stops and returns only an error.
@@ -88,6 +278,100 @@ func quote(s string) string {
 // JavaScript's implicit conversion rules are also applied when boolean, string and numeric values meet.
 // Note that expressions also handle failure (eg, failing to find a key in an object, or selecting from a non-object)
 // by propagating null values, which can then be detected and handled by using || and && as one might in JavaScript.
+// When compiled with the RFC9535 option, evaluation instead follows the rules of RFC 9535.
+//
+// Wildcards, filters and ".." visit the members of objects in order of their names, so results are repeatable,
+// unless the WithKeyOrder option or InKeyOrder says otherwise.
 func (path *JSONPath) Eval(root interface{}) ([]interface{}, error) {
 	return path.prog.Run(root)
 }
+
+// InKeyOrder returns a copy of the compiled path that visits object members in the given order.
+// It is cheap, so it can be used to apply a path to each document in the DocumentOrder returned by DecodeOrdered.
+func (path *JSONPath) InKeyOrder(order KeyOrder) *JSONPath {
+	opts := append(path.opts[:len(path.opts):len(path.opts)], mach.WithKeyOrder(order))
+	return &JSONPath{expr: path.expr, path: path.path, prog: path.prog.InKeyOrder(order), opts: opts}
+}
+
+// Limits bounds the resources used by EvalContext. A zero field means no limit.
+type Limits = mach.Limits
+
+// LimitError is the error returned by EvalContext when evaluation exceeds one of its Limits.
+type LimitError = mach.LimitError
+
+// Errors identifying the limit exceeded, in a LimitError.
+var (
+	ErrStepLimit   = mach.ErrStepLimit   // Limits.MaxSteps: machine instructions executed
+	ErrOutputLimit = mach.ErrOutputLimit // Limits.MaxOutput: values selected at any step
+	ErrDepthLimit  = mach.ErrDepthLimit  // Limits.MaxDepth: depth of the walk for ".."
+	ErrResultLimit = mach.ErrResultLimit // Limits.MaxResults: values in the result
+)
+
+// EvalContext is like Eval, but stops early if ctx is cancelled (or its deadline passes), returning ctx.Err(),
+// or if evaluation exceeds any of the given limits, returning a *LimitError (which errors.Is matches with ErrStepLimit, etc).
+// It allows untrusted paths to be evaluated safely: a path such as $..*..*..* can take a long time on a large document.
+func (path *JSONPath) EvalContext(ctx context.Context, root interface{}, limits Limits) ([]interface{}, error) {
+	return path.prog.RunContext(ctx, root, limits)
+}
+
+// EvalNodes is like Eval, but returns each selected value as a Node that also gives its location in the document.
+// The location of a member name selected by "~" is that of the member.
+func (path *JSONPath) EvalNodes(root interface{}) ([]Node, error) {
+	return path.prog.RunNodes(root)
+}
+
+// ErrNames is returned by Set, Update and Delete for a path that selects member names, which are not values in the document.
+var ErrNames = mach.ErrNames
+
+// Set replaces each value selected by the path in the document root by value, returning the resulting root
+// (which is value itself if the path is just "$") and the number of values replaced.
+// The document is changed in place, and the same value (not a copy) is stored at each location.
+// A run-time error in evaluating the path stops Set before any changes are made.
+// Set, Update and Delete return ErrNames if the path selects member names (with "~") instead of values.
+func (path *JSONPath) Set(root interface{}, value interface{}) (interface{}, int, error) {
+	return path.prog.Set(root, value)
+}
+
+// Update replaces each value selected by the path in the document root by the result of applying f to the current value,
+// returning the resulting root and the number of values replaced.
+// The document is changed in place. If f returns an error, Update stops and returns it, leaving earlier changes in place.
+// Locations are visited deepest and last first, so f sees a structure after any changes to values within it.
+func (path *JSONPath) Update(root interface{}, f func(old interface{}) (interface{}, error)) (interface{}, int, error) {
+	return path.prog.Update(root, f)
+}
+
+// Delete removes each value selected by the path from its containing object or array in the document root,
+// returning the resulting root and the number of values removed.
+// The document is changed in place; because deleting array elements shortens the array,
+// the result must be used instead of the original root (which is nil if the path is just "$").
+func (path *JSONPath) Delete(root interface{}) (interface{}, int, error) {
+	return path.prog.Delete(root)
+}
+
+// EvalStream evaluates the path against each JSON value read from r in turn, calling emit with each value selected,
+// without first decoding the whole document. Only values that the path might select, or that a filter must test,
+// are decoded (with numbers as float64, as by json.Unmarshal).
+// If emit returns an error, EvalStream stops and returns that error.
+//
+// Only some paths can be streamed: those made from member names, non-negative indices and slices, unions of those,
+// wildcards, "..", and filters ?(expr) whose expressions refer only to the current value (@).
+// For other paths, such as those with filters that refer to the root ($), or "..[?(expr)]" (which tests the root itself),
+// EvalStream returns an error wrapping mach.ErrNotStreamable, before reading r.
+//
+// Object members are visited in the order they appear, so the values emitted are those that Eval selects from
+// the document decoded by DecodeOrdered, using InKeyOrder with its DocumentOrder, including values selected more than once.
+// They are emitted in document order, however, which can differ from the order of Eval's results.
+func (path *JSONPath) EvalStream(r io.Reader, emit func(interface{}) error) error {
+	return path.EvalStreamDecoder(json.NewDecoder(r), emit)
+}
+
+// EvalStreamDecoder is like EvalStream, but reads the values from dec, respecting its settings (eg, UseNumber).
+func (path *JSONPath) EvalStreamDecoder(dec *json.Decoder, emit func(interface{}) error) error {
+	path.streamOnce.Do(func() {
+		path.stream, path.streamErr = mach.CompileStream(*path.path, path.opts...)
+	})
+	if path.streamErr != nil {
+		return path.streamErr
+	}
+	return path.stream.EvalDecoder(dec, emit)
+}
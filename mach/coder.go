@@ -9,8 +9,27 @@ import (
 
 var (
 	ErrTooManyVals = errors.New("program has too many values")
+	ErrUnknownFunc = errors.New("call of unknown function")
+	ErrArgCount    = errors.New("wrong argument count")
 )
 
+// CallError is returned by Compile for a call of an unknown function, or with the wrong number of arguments.
+// Its Offset locates the function's name in the source of the path, for diagnostics.
+type CallError struct {
+	Name   string // the function's name
+	Offset int    // byte offset of the name in the source
+	Err    error  // the problem, wrapping ErrUnknownFunc or ErrArgCount
+}
+
+func (e *CallError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error, allowing errors.Is(err, ErrArgCount) and similar.
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
 // builder is the state when building a program
 type builder struct {
 	vals map[paths.Val]uint32 // map value to its index in prog.vals
@@ -18,38 +37,83 @@ type builder struct {
 }
 
 // Compile compiles a Path into a Program for a small abstract machine that evaluates paths and expressions.
-func Compile(path paths.Path) (*Program, error) {
-	prog := &Program{}
+// Options, if any, change the default settings of the Program.
+func Compile(path paths.Path, opts ...Option) (*Program, error) {
+	prog := &Program{functions: predefined(), keyOrder: SortedKeys, regexpCacheSize: DefaultRegexpCacheSize}
+	for _, opt := range opts {
+		opt(prog)
+	}
+	prog.regexps = newRegexpCache(prog.regexpCacheSize)
 	b := &builder{vals: make(map[paths.Val]uint32), prog: prog}
+	err := b.codePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// codePath generates code for the steps of a path, or of a query in an expression.
+// Each step sets prog.final, so it is left by the last step of the path itself, not of a query within it.
+func (b *builder) codePath(path paths.Path) error {
+	prog := b.prog
 	for _, step := range path {
+		spc := prog.size()
 		if step.Op.IsLeaf() && step.Op.HasVal() {
 			// leaf carries a value index
 			err := b.codeVal(step.Op, step.Args[0])
 			if err != nil {
-				return nil, err
+				return err
 			}
 			continue
 		}
 		switch step.Op {
-		case paths.OpNestMember, paths.OpNestFilter, paths.OpNestSelect, paths.OpNestUnion, paths.OpNestWild:
+		case paths.OpUnion, paths.OpNestUnion:
+			var err error
+			switch {
+			case isSelectors(step.Args):
+				err = b.codeUnion(step.Args, step.Op == paths.OpNestUnion)
+			case step.Op == paths.OpNestUnion:
+				err = b.codeLoop(step, paths.OpNest)
+			default:
+				_, err = b.codeStep(step)
+			}
+			if err != nil {
+				return err
+			}
+		case paths.OpNestMember, paths.OpNestFilter, paths.OpNestSelect, paths.OpNestWild:
 			err := b.codeLoop(step, paths.OpNest)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		case paths.OpFilter:
 			err := b.codeLoop(step, paths.OpFor)
 			if err != nil {
-				return nil, err
+				return err
+			}
+		case paths.OpParent, paths.OpKey:
+			// both need to know where each value is
+			prog.track = true
+			if step.Op == paths.OpKey {
+				prog.names = true
+			}
+			_, err := b.codeStep(step)
+			if err != nil {
+				return err
 			}
 		default:
 			// general case
 			_, err := b.codeStep(step)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
+		prog.final = spc
+		if op := prog.orders[spc].op(); op != paths.OpFor && op != paths.OpNest && op != paths.OpUnionFor {
+			// the step's operator follows its arguments
+			prog.final = prog.size() - 1
+		}
 	}
-	return prog, nil
+	return nil
 }
 
 func (b *builder) codeLoop(step *paths.Step, intro paths.Op) error {
@@ -64,6 +128,46 @@ func (b *builder) codeLoop(step *paths.Step, intro paths.Op) error {
 	return nil
 }
 
+// isSelectors returns true if the arguments of a union are Steps, because it has a wildcard or filter among its selectors.
+func isSelectors(args []paths.Val) bool {
+	_, ok := args[0].(*paths.Step)
+	return ok
+}
+
+// codeUnion generates code for a union whose selectors are Steps, which apply in turn to each value in the output set,
+// or to dot in a loop over its descendants (nest), so that the union's results for each value are in selector order.
+// The loop for a filter among them is nested in the union's own loop.
+func (b *builder) codeUnion(sels []paths.Val, nest bool) error {
+	prog := b.prog
+	fpc, dot := 0, 0
+	if nest {
+		fpc = prog.asm(mkSmall(paths.OpNest, 0))
+		dot = 1 // select from dot, not the output set
+	}
+	upc := prog.asm(mkSmall(paths.OpUnionFor, dot))
+	for i, sel := range sels {
+		if i > 0 {
+			prog.asm(mkSmall(paths.OpUnionNext, 0))
+		}
+		var err error
+		step := sel.(*paths.Step)
+		if step.Op == paths.OpFilter {
+			err = b.codeLoop(step, paths.OpFor)
+		} else {
+			_, err = b.codeStep(step)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	prog.asm(mkSmall(paths.OpUnionRep, upc+1))
+	if nest {
+		prog.asm(mkSmall(paths.OpRep, upc))
+		prog.patch(fpc, mkSmall(paths.OpNest, prog.size()))
+	}
+	return nil
+}
+
 func (b *builder) codeStep(step *paths.Step) (int, error) {
 	prog := b.prog
 	pc := prog.size()
@@ -114,10 +218,26 @@ func (b *builder) codeExpr(expr paths.Expr) error {
 	if expr == nil {
 		panic("unexpected nil expr")
 	}
+	if q, ok := expr.(*paths.QueryLeaf); ok {
+		return b.codeQuery(q)
+	}
 	if expr.IsLeaf() {
 		return b.codeLeaf(expr)
 	}
 	t := expr.(*paths.Inner)
+	switch t.Op {
+	case paths.OpCall:
+		err := b.checkCall(t)
+		if err != nil {
+			return err
+		}
+	case paths.OpCond:
+		return b.codeCond(t)
+	case paths.OpCoalesce:
+		return b.codeCoalesce(t)
+	case paths.OpAnd, paths.OpOr:
+		return b.codeLogical(t)
+	}
 	for _, k := range t.Kids {
 		err := b.codeExpr(k)
 		if err != nil {
@@ -128,8 +248,105 @@ func (b *builder) codeExpr(expr paths.Expr) error {
 	return nil
 }
 
+// codeQuery generates code for a query in an expression, which selects values starting from @ or $,
+// and leaves the resulting nodeList on the stack.
+func (b *builder) codeQuery(q *paths.QueryLeaf) error {
+	root := 0
+	if q.Root == paths.OpRoot {
+		root = 1
+	}
+	b.prog.asm(mkSmall(paths.OpQuery, root))
+	names := b.prog.names
+	err := b.codePath(q.Path)
+	if err != nil {
+		return err
+	}
+	// member names selected within the query are not results that Set could change
+	b.prog.names = names
+	b.prog.asm(mkSmall(paths.OpQueryEnd, 0))
+	return nil
+}
+
+// codeCond generates code for e ? e1 : e2, evaluating only one of e1 and e2:
+//
+//	e Cond.else e1 Jump.end else: e2 end:
+func (b *builder) codeCond(t *paths.Inner) error {
+	prog := b.prog
+	err := b.codeExpr(t.Kids[0])
+	if err != nil {
+		return err
+	}
+	cpc := prog.asm(mkSmall(paths.OpCond, 0))
+	err = b.codeExpr(t.Kids[1])
+	if err != nil {
+		return err
+	}
+	jpc := prog.asm(mkSmall(paths.OpJump, 0))
+	prog.patch(cpc, mkSmall(paths.OpCond, prog.size()))
+	err = b.codeExpr(t.Kids[2])
+	if err != nil {
+		return err
+	}
+	prog.patch(jpc, mkSmall(paths.OpJump, prog.size()))
+	return nil
+}
+
+// codeLogical generates code for e1 && e2 and e1 || e2, evaluating e2 only if e1 does not decide the result:
+//
+//	e1 And.end e2 end:
+func (b *builder) codeLogical(t *paths.Inner) error {
+	prog := b.prog
+	err := b.codeExpr(t.Kids[0])
+	if err != nil {
+		return err
+	}
+	cpc := prog.asm(mkSmall(t.Op, 0))
+	err = b.codeExpr(t.Kids[1])
+	if err != nil {
+		return err
+	}
+	prog.patch(cpc, mkSmall(t.Op, prog.size()))
+	return nil
+}
+
+// codeCoalesce generates code for e1 ?? e2, evaluating e2 only if e1 is null or nothing:
+//
+//	e1 Coalesce.end e2 end:
+func (b *builder) codeCoalesce(t *paths.Inner) error {
+	prog := b.prog
+	err := b.codeExpr(t.Kids[0])
+	if err != nil {
+		return err
+	}
+	cpc := prog.asm(mkSmall(paths.OpCoalesce, 0))
+	err = b.codeExpr(t.Kids[1])
+	if err != nil {
+		return err
+	}
+	prog.patch(cpc, mkSmall(paths.OpCoalesce, prog.size()))
+	return nil
+}
+
+// checkCall checks that a call names a function available to the Program, with the right number of arguments,
+// returning a *CallError if not.
+func (b *builder) checkCall(call *paths.Inner) error {
+	id := call.Kids[0].(*paths.NameLeaf)
+	fn, ok := b.prog.function(id.Name)
+	if !ok {
+		return &CallError{id.Name, id.Offset, fmt.Errorf("%w: %s", ErrUnknownFunc, id.Name)}
+	}
+	if n := len(call.Kids) - 1; !fn.accepts(n) {
+		return &CallError{id.Name, id.Offset, fmt.Errorf("%s: %w: need %s, got %d", id.Name, ErrArgCount, fn.arity(), n)}
+	}
+	return nil
+}
+
 func (b *builder) codeLeaf(expr paths.Expr) error {
 	op := expr.Opcode()
+	if op == paths.OpCurrentKey || op == paths.OpCurrentPath {
+		// needs the location of @
+		b.prog.track = true
+	}
 	if !op.HasVal() {
 		return b.codeOp(op, nil)
 	}
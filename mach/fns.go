@@ -1,29 +1,119 @@
 package mach
 
 import (
+	"fmt"
 	"math"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 	"unicode/utf8"
-	//	"github.com/forsyth/jsonpath/paths"
+
+	"github.com/forsyth/jsonpath/paths"
 )
 
 // Function represents a predefined function with na args (or AnyNumber) with body fn.
+// If some arguments are optional, na is the least number and max the greatest (or AnyNumber for no limit).
+// A function whose result depends on the settings of the Program calling it (eg, its KeyOrder),
+// or on the state of the run (eg, the time of now()), instead has body pfn, which is also given the machine running the Program.
 type Function struct {
-	na int
-	fn func([]JSON) JSON
+	na  int
+	max int
+	fn  func([]JSON) JSON
+	pfn func(*machine, []JSON) JSON
 }
 
 // AnyNumber as Function.na means any number of args.
 const AnyNumber = -1
 
+// NewFunction returns a Function that takes na arguments (or any number, if na is AnyNumber), with body fn.
+// The body is given the values of the arguments, and returns the function's result.
+// As with the built-in functions, an error value as the result (eg, ErrType) yields "nothing":
+// it does not stop evaluation, but propagates like a missing value.
+func NewFunction(na int, fn func(args []JSON) JSON) Function {
+	if fn == nil {
+		panic("mach.NewFunction: nil body")
+	}
+	if na < AnyNumber {
+		panic("mach.NewFunction: invalid argument count")
+	}
+	return Function{na: na, fn: fn}
+}
+
+// Arity returns the number of arguments the function takes (the least number, if some are optional), or AnyNumber.
+func (f Function) Arity() int {
+	return f.na
+}
+
+// accepts returns true if the function can be called with n arguments.
+func (f Function) accepts(n int) bool {
+	switch {
+	case f.na == AnyNumber || n == f.na:
+		return true
+	case n < f.na:
+		return false
+	default:
+		return f.max == AnyNumber || n <= f.max
+	}
+}
+
+// arity describes the number of arguments the function accepts, for diagnostics.
+func (f Function) arity() string {
+	switch {
+	case f.max == AnyNumber:
+		return fmt.Sprintf("at least %d", f.na)
+	case f.max > f.na:
+		return fmt.Sprintf("%d to %d", f.na, f.max)
+	default:
+		return fmt.Sprint(f.na)
+	}
+}
+
+// registered is the set of predefined functions: the built-in functions and any added by Register.
+// Register replaces the map instead of changing it, so Programs can share it without locking.
+var (
+	regLock    sync.Mutex
+	registered = functions
+)
+
+// Register adds fn to the set of predefined functions under the given name, replacing any function of that name,
+// including a built-in one. It affects Programs compiled subsequently.
+func Register(name string, fn Function) {
+	regLock.Lock()
+	defer regLock.Unlock()
+	fns := make(map[string]Function, len(registered)+1)
+	for k, v := range registered {
+		fns[k] = v
+	}
+	fns[name] = fn
+	registered = fns
+}
+
+// function returns the Function named id that the Program can call, and true, or false if there is none.
+func (p *Program) function(id string) (Function, bool) {
+	fn, ok := p.functions[id]
+	if ok && fn.fn == nil && fn.pfn == nil {
+		// zero Function
+		return fn, false
+	}
+	return fn, ok
+}
+
+// predefined returns the current set of predefined functions, which must not be changed.
+func predefined() map[string]Function {
+	regLock.Lock()
+	defer regLock.Unlock()
+	return registered
+}
+
 // functions is the default set of predefined functions.
 var functions = map[string]Function{
 	"abs": {
-		1,
-		func(args []JSON) JSON {
-			switch n := args[0].(type) {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			switch n := number(args[0]).(type) {
 			case int:
 				w := int64(n)
 				if w < 0 {
@@ -49,8 +139,8 @@ var functions = map[string]Function{
 		},
 	},
 	"avg": {
-		1,
-		func(args []JSON) JSON {
+		na: 1,
+		fn: func(args []JSON) JSON {
 			if a, ok := args[0].([]JSON); ok {
 				var sum float64
 				for _, v := range a {
@@ -65,9 +155,9 @@ var functions = map[string]Function{
 		},
 	},
 	"ceil": {
-		1,
-		func(args []JSON) JSON {
-			switch f := args[0].(type) {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			switch f := number(args[0]).(type) {
 			case int, int64:
 				return f
 			case float64:
@@ -77,9 +167,31 @@ var functions = map[string]Function{
 			}
 		},
 	},
+	"clamp": {
+		na: 3,
+		fn: func(args []JSON) JSON {
+			// clamp(x, lo, hi): x limited to the range lo to hi
+			for _, v := range args {
+				if !isNumber(v) {
+					return ErrType
+				}
+			}
+			x, lo, hi := number(args[0]), number(args[1]), number(args[2])
+			if cvf(lo) > cvf(hi) {
+				return ErrType
+			}
+			if cvf(x) < cvf(lo) {
+				return lo
+			}
+			if cvf(x) > cvf(hi) {
+				return hi
+			}
+			return x
+		},
+	},
 	"contains": {
-		2,
-		func(args []JSON) JSON {
+		na: 2,
+		fn: func(args []JSON) JSON {
 			switch a := args[0].(type) {
 			case string:
 				// does string a contain args[1]?
@@ -100,9 +212,77 @@ var functions = map[string]Function{
 			}
 		},
 	},
+	"count_if": {
+		na:  1,
+		max: 2,
+		fn: func(args []JSON) JSON {
+			// count_if(a[, key]): number of elements of a that are true, or whose member at key is true
+			a, key, ok := arrayKeyArgs(args)
+			if !ok {
+				return ErrType
+			}
+			n := int64(0)
+			for _, v := range a {
+				if v, ok := keyPath(v, key); ok && cvb(v) {
+					n++
+				}
+			}
+			return n
+		},
+	},
+	"date_add": {
+		na: 3,
+		fn: func(args []JSON) JSON {
+			// date_add(t, n, unit): time value t plus n units (ms, s, m, h, d or w)
+			t, ok := timeArg(args[0])
+			if !ok || !isNumber(args[1]) {
+				return ErrType
+			}
+			unit, ok := unitArg(args[2])
+			if !ok {
+				return ErrType
+			}
+			return timeResult(float64(t) + cvf(args[1])*float64(unit))
+		},
+	},
+	"date_diff": {
+		na: 3,
+		fn: func(args []JSON) JSON {
+			// date_diff(t1, t2, unit): t1 - t2 in the given unit, as in date_add
+			t1, ok1 := timeArg(args[0])
+			t2, ok2 := timeArg(args[1])
+			if !ok1 || !ok2 {
+				return ErrType
+			}
+			unit, ok := unitArg(args[2])
+			if !ok {
+				return ErrType
+			}
+			if unit == 1 {
+				return t1 - t2
+			}
+			return float64(t1-t2) / float64(unit)
+		},
+	},
+	"distinct": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			a, ok := args[0].([]JSON)
+			if !ok {
+				return ErrType
+			}
+			result := []JSON{}
+			for _, v := range a {
+				if !searchJSON(result, v, true) {
+					result = append(result, v)
+				}
+			}
+			return result
+		},
+	},
 	"ends_with": {
-		2,
-		func(args []JSON) JSON {
+		na: 2,
+		fn: func(args []JSON) JSON {
 			a, b, ok := stringArgs(args)
 			if !ok {
 				return nothing
@@ -110,10 +290,43 @@ var functions = map[string]Function{
 			return strings.HasSuffix(a, b)
 		},
 	},
+	"exp": {na: 1, fn: floatFunc(math.Exp)},
+	"first": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			a, ok := args[0].([]JSON)
+			if !ok {
+				return ErrType
+			}
+			if len(a) == 0 {
+				return nothing
+			}
+			return a[0]
+		},
+	},
+	"flatten": {
+		na:  1,
+		max: 2,
+		fn: func(args []JSON) JSON {
+			// flatten(a[, depth]): replace arrays in a by their elements, down to depth levels (1 by default)
+			a, ok := args[0].([]JSON)
+			if !ok {
+				return ErrType
+			}
+			depth := 1
+			if len(args) == 2 {
+				depth, ok = intArg(args[1])
+				if !ok || depth < 0 {
+					return ErrType
+				}
+			}
+			return flattenArray([]JSON{}, a, depth)
+		},
+	},
 	"floor": {
-		1,
-		func(args []JSON) JSON {
-			switch f := args[0].(type) {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			switch f := number(args[0]).(type) {
 			case int, int64:
 				return f
 			case float64:
@@ -123,12 +336,188 @@ var functions = map[string]Function{
 			}
 		},
 	},
+	"format_time": {
+		na:  1,
+		max: 2,
+		fn: func(args []JSON) JSON {
+			// format_time(t[, layout]): time value t as a string in UTC, in RFC 3339 format or the given Go time layout
+			t, ok := timeArg(args[0])
+			if !ok {
+				return ErrType
+			}
+			layout := timeLayout
+			if len(args) == 2 {
+				layout, ok = args[1].(string)
+				if !ok {
+					return ErrType
+				}
+			}
+			return time.UnixMilli(t).UTC().Format(layout)
+		},
+	},
+	"from_entries": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			// from_entries(a): object with a member for each [key, value] pair in a, as to_entries returns
+			a, ok := args[0].([]JSON)
+			if !ok {
+				return ErrType
+			}
+			obj := make(map[string]JSON, len(a))
+			for _, v := range a {
+				pair, ok := v.([]JSON)
+				if !ok || len(pair) != 2 {
+					return ErrType
+				}
+				key, ok := pair[0].(string)
+				if !ok {
+					return ErrType
+				}
+				obj[key] = pair[1]
+			}
+			return obj
+		},
+	},
+	"get": {
+		na:  2,
+		max: 3,
+		fn: func(args []JSON) JSON {
+			// get(v, path[, default]): the value at key path in v (as for sort), or default if there is none
+			path, ok := args[1].(string)
+			if !ok {
+				return ErrType
+			}
+			if v, ok := keyPath(args[0], path); ok {
+				return v
+			}
+			if len(args) == 3 {
+				return args[2]
+			}
+			return nothing
+		},
+	},
+	"group_by": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			// group_by(a, key): object mapping each value of key (as a string) to the elements of a with that value
+			a, key, ok := arrayKeyArgs(args)
+			if !ok {
+				return ErrType
+			}
+			groups := make(map[string]JSON)
+			for _, v := range a {
+				k, ok := keyPath(v, key)
+				if !ok {
+					continue
+				}
+				if !isSimple(k) {
+					return ErrType
+				}
+				name := cvs(k)
+				group, _ := groups[name].([]JSON)
+				groups[name] = append(group, v)
+			}
+			return groups
+		},
+	},
+	"has": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			// has(v, key): whether object v has a member named key, or array v has an element at index key
+			switch a := args[0].(type) {
+			case map[string]JSON:
+				key, ok := args[1].(string)
+				if !ok {
+					return ErrType
+				}
+				_, ok = a[key]
+				return ok
+			case []JSON:
+				i, ok := intArg(args[1])
+				if !ok {
+					return ErrType
+				}
+				return i >= 0 && i < len(a)
+			default:
+				return ErrType
+			}
+		},
+	},
+	"index_of": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			switch a := args[0].(type) {
+			case string:
+				// code point index of string args[1] in a
+				b, ok := args[1].(string)
+				if !ok {
+					return ErrType
+				}
+				i := strings.Index(a, b)
+				if i < 0 {
+					return int64(-1)
+				}
+				return int64(utf8.RuneCountInString(a[:i]))
+			case []JSON:
+				// index of value args[1] in slice a
+				for i, v := range a {
+					if eqVal(v, args[1]) {
+						return int64(i)
+					}
+				}
+				return int64(-1)
+			default:
+				return ErrType
+			}
+		},
+	},
+	"is_array":   {na: 1, fn: typeTest(Array)},
+	"is_boolean": {na: 1, fn: typeTest(Boolean)},
+	"is_integer": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			switch x := number(args[0]).(type) {
+			case int, int64:
+				return true
+			case float64:
+				return x == math.Trunc(x) && !math.IsInf(x, 0)
+			default:
+				return false
+			}
+		},
+	},
+	"is_null":   {na: 1, fn: typeTest(Null)},
+	"is_number": {na: 1, fn: typeTest(Number)},
+	"is_object": {na: 1, fn: typeTest(Object)},
+	"is_string": {na: 1, fn: typeTest(String)},
+	"join": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			a, ok := args[0].([]JSON)
+			if !ok {
+				return ErrType
+			}
+			sep, ok := args[1].(string)
+			if !ok {
+				return ErrType
+			}
+			strs := make([]string, len(a))
+			for i, v := range a {
+				s, ok := v.(string)
+				if !ok {
+					return ErrType
+				}
+				strs[i] = s
+			}
+			return strings.Join(strs, sep)
+		},
+	},
 	"keys": {
-		1,
-		func(args []JSON) JSON {
+		na: 1,
+		pfn: func(m *machine, args []JSON) JSON {
 			if obj, ok := args[0].(map[string]JSON); ok {
 				keys := make([]JSON, 0, len(obj))
-				for k, _ := range obj {
+				for _, k := range m.prog.keyOrder.Keys(obj) {
 					keys = append(keys, k)
 				}
 				return keys
@@ -137,9 +526,22 @@ var functions = map[string]Function{
 			}
 		},
 	},
+	"last": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			a, ok := args[0].([]JSON)
+			if !ok {
+				return ErrType
+			}
+			if len(a) == 0 {
+				return nothing
+			}
+			return a[len(a)-1]
+		},
+	},
 	"length": {
-		1,
-		func(args []JSON) JSON {
+		na: 1,
+		fn: func(args []JSON) JSON {
 			switch a := args[0].(type) {
 			case string:
 				return int64(utf8.RuneCountInString(a))
@@ -152,9 +554,12 @@ var functions = map[string]Function{
 			}
 		},
 	},
+	"log":   {na: 1, fn: floatFunc(math.Log)},
+	"log10": {na: 1, fn: floatFunc(math.Log10)},
+	"lower": {na: 1, fn: stringFunc(strings.ToLower)},
 	"max": {
-		AnyNumber,
-		func(args []JSON) JSON {
+		na: AnyNumber,
+		fn: func(args []JSON) JSON {
 			if len(args) == 0 {
 				return nil
 			}
@@ -169,9 +574,34 @@ var functions = map[string]Function{
 			return minMaxArray(args, maxArith, maxString)
 		},
 	},
+	"max_by": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			return minMaxBy(args, func(i, j int64) bool { return i > j },
+				func(x, y float64) bool { return x > y }, func(s, t string) bool { return s > t })
+		},
+	},
+	"merge": {
+		na:  1,
+		max: AnyNumber,
+		fn: func(args []JSON) JSON {
+			// merge(a, b, ...): object with the members of all the objects, the last of each name taking precedence
+			result := make(map[string]JSON)
+			for _, v := range args {
+				obj, ok := v.(map[string]JSON)
+				if !ok {
+					return ErrType
+				}
+				for k, el := range obj {
+					result[k] = el
+				}
+			}
+			return result
+		},
+	},
 	"min": {
-		AnyNumber,
-		func(args []JSON) JSON {
+		na: AnyNumber,
+		fn: func(args []JSON) JSON {
 			if len(args) == 0 {
 				return nil
 			}
@@ -186,9 +616,136 @@ var functions = map[string]Function{
 			return minMaxArray(args, minArith, minString)
 		},
 	},
+	"min_by": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			return minMaxBy(args, func(i, j int64) bool { return i < j },
+				func(x, y float64) bool { return x < y }, func(s, t string) bool { return s < t })
+		},
+	},
+	"now": {
+		na: 0,
+		pfn: func(m *machine, args []JSON) JSON {
+			return m.now().UnixMilli()
+		},
+	},
+	"pad": {
+		na:  2,
+		max: 3,
+		fn: func(args []JSON) JSON {
+			// pad(s, width[, fill]): right-justify s in width code points, or left-justify if width is negative
+			s, ok := args[0].(string)
+			if !ok {
+				return ErrType
+			}
+			width, ok := intArg(args[1])
+			if !ok {
+				return ErrType
+			}
+			fill := " "
+			if len(args) == 3 {
+				fill, ok = args[2].(string)
+				if !ok || utf8.RuneCountInString(fill) != 1 {
+					return ErrType
+				}
+			}
+			if width < -maxStringResult || width > maxStringResult {
+				return ErrOverflow
+			}
+			left := width >= 0
+			if !left {
+				width = -width
+			}
+			n := width - utf8.RuneCountInString(s)
+			if n <= 0 {
+				return s
+			}
+			if len(fill)*n > maxStringResult {
+				return ErrOverflow
+			}
+			if left {
+				return strings.Repeat(fill, n) + s
+			}
+			return s + strings.Repeat(fill, n)
+		},
+	},
+	"parse_time": {
+		na:  1,
+		max: 2,
+		fn: func(args []JSON) JSON {
+			// parse_time(s[, layout]): the time value of s, in RFC 3339 format or the given Go time layout (in UTC if it has no zone)
+			s, ok := args[0].(string)
+			if !ok {
+				return ErrType
+			}
+			layout := time.RFC3339
+			if len(args) == 2 {
+				layout, ok = args[1].(string)
+				if !ok {
+					return ErrType
+				}
+			}
+			tm, err := time.Parse(layout, s)
+			if err != nil {
+				return err
+			}
+			ms, ok := timeMillis(tm)
+			if !ok {
+				return ErrOverflow
+			}
+			return ms
+		},
+	},
+	"pick": {
+		na:  1,
+		max: AnyNumber,
+		fn: func(args []JSON) JSON {
+			// pick(obj, key, ...): object with just the members of obj with the given names, each a string or array of strings
+			obj, ok := args[0].(map[string]JSON)
+			if !ok {
+				return ErrType
+			}
+			result := make(map[string]JSON)
+			pick := func(key JSON) bool {
+				k, ok := key.(string)
+				if !ok {
+					return false
+				}
+				if v, ok := obj[k]; ok {
+					result[k] = v
+				}
+				return true
+			}
+			for _, key := range args[1:] {
+				if keys, ok := key.([]JSON); ok {
+					for _, k := range keys {
+						if !pick(k) {
+							return ErrType
+						}
+					}
+				} else if !pick(key) {
+					return ErrType
+				}
+			}
+			return result
+		},
+	},
+	"pow": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			x, y := number(args[0]), number(args[1])
+			if !isNumber(x) || !isNumber(y) {
+				return ErrType
+			}
+			if isInt(x) && isInt(y) && cvi(y) >= 0 {
+				return powInt(cvi(x), cvi(y))
+			}
+			return floatResult(math.Pow(cvf(x), cvf(y)))
+		},
+	},
 	"prod": {
-		1,
-		func(args []JSON) JSON {
+		na: 1,
+		fn: func(args []JSON) JSON {
 			if a, ok := args[0].([]JSON); ok {
 				if len(a) == 0 {
 					return nil
@@ -203,9 +760,184 @@ var functions = map[string]Function{
 			return ErrType
 		},
 	},
+	"repeat": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			s, ok := args[0].(string)
+			if !ok {
+				return ErrType
+			}
+			n, ok := intArg(args[1])
+			if !ok || n < 0 {
+				return ErrType
+			}
+			if n > 0 && len(s) > maxStringResult/n {
+				return ErrOverflow
+			}
+			return strings.Repeat(s, n)
+		},
+	},
+	"replace": {
+		na: 3,
+		pfn: func(m *machine, args []JSON) JSON {
+			s, re, ok := stringArgs(args[0:2])
+			if !ok {
+				return ErrType
+			}
+			repl, ok := args[2].(string)
+			if !ok {
+				return ErrType
+			}
+			prog, err := m.prog.regexp(re)
+			if err != nil {
+				return err
+			}
+			return prog.ReplaceAllString(s, repl)
+		},
+	},
+	"reverse": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			a, ok := args[0].([]JSON)
+			if !ok {
+				return ErrType
+			}
+			result := make([]JSON, len(a))
+			for i, v := range a {
+				result[len(a)-1-i] = v
+			}
+			return result
+		},
+	},
+	"round": {
+		na:  1,
+		max: 2,
+		fn: func(args []JSON) JSON {
+			// round(x[, digits]): x rounded to the nearest integer, or to digits decimal places, with halves rounded up as in JavaScript
+			digits := 0
+			if len(args) == 2 {
+				var ok bool
+				digits, ok = intArg(args[1])
+				if !ok || digits < -maxDigits || digits > maxDigits {
+					return ErrType
+				}
+			}
+			switch x := number(args[0]).(type) {
+			case int, int64:
+				if digits >= 0 {
+					return x
+				}
+				return floatResult(roundDigits(cvf(x), digits))
+			case float64:
+				return floatResult(roundDigits(x, digits))
+			default:
+				return ErrType
+			}
+		},
+	},
+	"sign": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			switch x := number(args[0]).(type) {
+			case int, int64:
+				switch n := cvi(x); {
+				case n < 0:
+					return int64(-1)
+				case n > 0:
+					return int64(1)
+				default:
+					return int64(0)
+				}
+			case float64:
+				switch {
+				case x < 0:
+					return -1.0
+				case x > 0:
+					return 1.0
+				default:
+					return x // 0, -0 or NaN
+				}
+			default:
+				return ErrType
+			}
+		},
+	},
+	"slice": {
+		na:  1,
+		max: 4,
+		fn: func(args []JSON) JSON {
+			// slice(a[, start[, end[, step]]]): the elements of a selected as by a[start:end:step], where null leaves a bound out
+			a, ok := args[0].([]JSON)
+			if !ok {
+				return ErrType
+			}
+			var bounds [3]paths.Val
+			for i, arg := range args[1:] {
+				if arg == nil {
+					continue
+				}
+				n, ok := intArg(arg)
+				if !ok {
+					return ErrType
+				}
+				bounds[i] = paths.IntVal(int64(n))
+			}
+			return append([]JSON{}, slicing(a, &paths.Slice{Start: bounds[0], End: bounds[1], Stride: bounds[2]})...)
+		},
+	},
+	"sort": {
+		na:  1,
+		max: 2,
+		fn: func(args []JSON) JSON {
+			// sort(a[, key]): a ordered by its elements, or their members at key, which must be all numbers or all strings
+			a, key, ok := arrayKeyArgs(args)
+			if !ok {
+				return ErrType
+			}
+			keys := make([]JSON, len(a))
+			for i, v := range a {
+				keys[i], ok = keyPath(v, key)
+				if !ok {
+					return ErrType
+				}
+			}
+			if isNothing(minMaxArray(keys, minArith, minString)) {
+				// mixed types, or types without an order
+				return ErrType
+			}
+			order := make([]int, len(a))
+			for i := range order {
+				order[i] = i
+			}
+			sort.SliceStable(order, func(i, j int) bool {
+				return relation(keys[order[i]], keys[order[j]], func(i, j int64) bool { return i < j },
+					func(x, y float64) bool { return x < y }, func(s, t string) bool { return s < t }) == true
+			})
+			result := make([]JSON, len(a))
+			for i, o := range order {
+				result[i] = a[o]
+			}
+			return result
+		},
+	},
+	"split": {
+		na: 2,
+		fn: func(args []JSON) JSON {
+			s, sep, ok := stringArgs(args)
+			if !ok {
+				return ErrType
+			}
+			result := []JSON{}
+			for _, part := range strings.Split(s, sep) {
+				result = append(result, part)
+			}
+			return result
+		},
+	},
+	"sqrt": {na: 1, fn: floatFunc(math.Sqrt)},
 	"starts_with": {
-		2,
-		func(args []JSON) JSON {
+		na: 2,
+		fn: func(args []JSON) JSON {
 			a, b, ok := stringArgs(args)
 			if !ok {
 				return nothing
@@ -213,9 +945,38 @@ var functions = map[string]Function{
 			return strings.HasPrefix(a, b)
 		},
 	},
+	"substring": {
+		na:  2,
+		max: 3,
+		fn: func(args []JSON) JSON {
+			// substring(s, start[, end]), indexing code points as in a slice: negative values count from the end
+			s, ok := args[0].(string)
+			if !ok {
+				return ErrType
+			}
+			runes := []rune(s)
+			start, ok := intArg(args[1])
+			if !ok {
+				return ErrType
+			}
+			end := len(runes)
+			if len(args) == 3 {
+				end, ok = intArg(args[2])
+				if !ok {
+					return ErrType
+				}
+			}
+			start = runeIndex(start, len(runes))
+			end = runeIndex(end, len(runes))
+			if end <= start {
+				return ""
+			}
+			return string(runes[start:end])
+		},
+	},
 	"sum": {
-		1,
-		func(args []JSON) JSON {
+		na: 1,
+		fn: func(args []JSON) JSON {
 			if a, ok := args[0].([]JSON); ok {
 				if len(a) == 0 {
 					return float64(0.0)
@@ -227,10 +988,24 @@ var functions = map[string]Function{
 			return ErrType
 		},
 	},
+	"to_entries": {
+		na: 1,
+		pfn: func(m *machine, args []JSON) JSON {
+			// to_entries(obj): array of the [key, value] pairs of the members of obj
+			if obj, ok := args[0].(map[string]JSON); ok {
+				entries := make([]JSON, 0, len(obj))
+				for _, k := range m.prog.keyOrder.Keys(obj) {
+					entries = append(entries, []JSON{k, obj[k]})
+				}
+				return entries
+			}
+			return ErrType
+		},
+	},
 	"to_number": {
-		1,
-		func(args []JSON) JSON {
-			switch a := args[0].(type) {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			switch a := number(args[0]).(type) {
 			case int, int64, float64:
 				return a
 			case string:
@@ -245,13 +1020,13 @@ var functions = map[string]Function{
 		},
 	},
 	"tokenize": {
-		2,
-		func(args []JSON) JSON {
+		na: 2,
+		pfn: func(m *machine, args []JSON) JSON {
 			s, re, ok := stringArgs(args)
 			if !ok {
 				return ErrType
 			}
-			prog, err := regexp.Compile(re)
+			prog, err := m.prog.regexp(re)
 			if err != nil {
 				return err
 			}
@@ -263,6 +1038,143 @@ var functions = map[string]Function{
 			return result
 		},
 	},
+	"trim":       {na: 1, max: 2, fn: trimFunc(strings.TrimSpace, strings.Trim)},
+	"trim_left":  {na: 1, max: 2, fn: trimFunc(func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) }, strings.TrimLeft)},
+	"trim_right": {na: 1, max: 2, fn: trimFunc(func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) }, strings.TrimRight)},
+	"trunc": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			switch x := number(args[0]).(type) {
+			case int, int64:
+				return x
+			case float64:
+				return math.Trunc(x)
+			default:
+				return ErrType
+			}
+		},
+	},
+	"type": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			return jsonType(args[0]).String()
+		},
+	},
+	"upper": {na: 1, fn: stringFunc(strings.ToUpper)},
+	"values": {
+		na: 1,
+		pfn: func(m *machine, args []JSON) JSON {
+			if obj, ok := args[0].(map[string]JSON); ok {
+				vals := make([]JSON, 0, len(obj))
+				for _, k := range m.prog.keyOrder.Keys(obj) {
+					vals = append(vals, obj[k])
+				}
+				return vals
+			}
+			return ErrType
+		},
+	},
+	"zip": {
+		na:  1,
+		max: AnyNumber,
+		fn: func(args []JSON) JSON {
+			// zip(a, b, ...): array of arrays of corresponding elements, as long as the shortest argument
+			arrays := make([][]JSON, len(args))
+			n := -1
+			for i, v := range args {
+				a, ok := v.([]JSON)
+				if !ok {
+					return ErrType
+				}
+				if n < 0 || len(a) < n {
+					n = len(a)
+				}
+				arrays[i] = a
+			}
+			result := make([]JSON, n)
+			for i := range result {
+				tuple := make([]JSON, len(arrays))
+				for j, a := range arrays {
+					tuple[j] = a[i]
+				}
+				result[i] = tuple
+			}
+			return result
+		},
+	},
+}
+
+// rfcFunctions is the set of function extensions defined by RFC 9535, with its semantics.
+var rfcFunctions = map[string]Function{
+	"count": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			if l, ok := args[0].(nodeList); ok {
+				return int64(len(l))
+			}
+			if isNothing(args[0]) {
+				return int64(0)
+			}
+			return int64(1)
+		},
+	},
+	"length": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			switch a := args[0].(type) {
+			case string:
+				return int64(utf8.RuneCountInString(a))
+			case []JSON:
+				return int64(len(a))
+			case map[string]JSON:
+				return int64(len(a))
+			default:
+				return nothing
+			}
+		},
+	},
+	"match": {
+		na: 2,
+		pfn: func(m *machine, args []JSON) JSON {
+			return iMatch(m.prog, args, true)
+		},
+	},
+	"search": {
+		na: 2,
+		pfn: func(m *machine, args []JSON) JSON {
+			return iMatch(m.prog, args, false)
+		},
+	},
+	"value": {
+		na: 1,
+		fn: func(args []JSON) JSON {
+			if l, ok := args[0].(nodeList); ok {
+				return l.value()
+			}
+			return args[0]
+		},
+	},
+}
+
+// iMatch returns true if args[0] is a string that matches (all of it, if whole is true) the I-Regexp in args[1].
+// As RFC 9535 requires, invalid arguments simply yield false.
+func iMatch(p *Program, args []JSON, whole bool) JSON {
+	s, pat, ok := stringArgs(args)
+	if !ok {
+		return false
+	}
+	re, err := iregexp(pat)
+	if err != nil {
+		return false
+	}
+	if whole {
+		re = `\A(?:` + re + `)\z`
+	}
+	prog, err := p.regexp(re)
+	if err != nil {
+		return false
+	}
+	return prog.MatchString(s)
 }
 
 func stringArgs(args []JSON) (string, string, bool) {
@@ -285,6 +1197,249 @@ func stringArgs(args []JSON) (string, string, bool) {
 	return a, b, true
 }
 
+// maxStringResult is the length in bytes of the longest string that functions such as pad and repeat will make.
+const maxStringResult = 1 << 24
+
+// intArg returns v as an int, and true, if it is an integer value (including a float64 with no fraction).
+func intArg(v JSON) (int, bool) {
+	switch n := number(v).(type) {
+	case int:
+		return n, true
+	case int64:
+		if int64(int(n)) != n {
+			return 0, false
+		}
+		return int(n), true
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt || n >= -math.MinInt {
+			return 0, false
+		}
+		return int(n), true
+	default:
+		return 0, false
+	}
+}
+
+// runeIndex returns index i into a string of n code points, counting from the end if negative, limited to 0 to n.
+func runeIndex(i, n int) int {
+	if i < 0 {
+		i += n
+		if i < 0 {
+			return 0
+		}
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// isNumber returns true if v is a number, but unlike isArith, not a boolean.
+func isNumber(v JSON) bool {
+	switch number(v).(type) {
+	case int, int64, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// floatResult returns the result f of a floating-point function, or ErrType if it is NaN, or ErrOverflow if it is infinite.
+func floatResult(f float64) JSON {
+	switch {
+	case math.IsNaN(f):
+		return ErrType
+	case math.IsInf(f, 0):
+		return ErrOverflow
+	default:
+		return f
+	}
+}
+
+// floatFunc returns the body of a function that applies f to its numeric argument, as a float64.
+func floatFunc(f func(float64) float64) func([]JSON) JSON {
+	return func(args []JSON) JSON {
+		if !isNumber(args[0]) {
+			return ErrType
+		}
+		return floatResult(f(cvf(args[0])))
+	}
+}
+
+// powInt returns x to the power y (which is not negative), or ErrOverflow if the result does not fit in an int64.
+func powInt(x, y int64) JSON {
+	r := int64(1)
+	for ; y > 0; y >>= 1 {
+		if y&1 != 0 {
+			if mulOverflows(r, x) {
+				return ErrOverflow
+			}
+			r *= x
+		}
+		if y > 1 {
+			if mulOverflows(x, x) {
+				return ErrOverflow
+			}
+			x *= x
+		}
+	}
+	return r
+}
+
+// mulOverflows returns true if a*b does not fit in an int64.
+func mulOverflows(a, b int64) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	if a == -1 || b == -1 {
+		return a == math.MinInt64 || b == math.MinInt64
+	}
+	return (a*b)/b != a
+}
+
+// maxDigits is the largest number of decimal places (either side of the point) that round accepts.
+const maxDigits = 308
+
+// roundDigits returns x rounded to the given number of decimal places, which may be negative, with halves rounded up.
+func roundDigits(x float64, digits int) float64 {
+	if digits == 0 {
+		return roundHalfUp(x)
+	}
+	scale := math.Pow10(digits)
+	if math.IsInf(x*scale, 0) {
+		// too many places to matter
+		return x
+	}
+	return roundHalfUp(x*scale) / scale
+}
+
+// roundHalfUp rounds x to the nearest integer, rounding halves towards +Inf, as JavaScript's Math.round.
+func roundHalfUp(x float64) float64 {
+	r := math.Floor(x)
+	if x-r >= 0.5 {
+		r++
+	}
+	return r
+}
+
+// minMaxBy returns the first element of args[0] whose member at key args[1] is least (or greatest) according to the given comparisons,
+// or nothing if the array is empty. The keys must be all numbers or all strings.
+func minMaxBy(args []JSON, intf func(int64, int64) bool, floatf func(float64, float64) bool, stringf func(string, string) bool) JSON {
+	a, key, ok := arrayKeyArgs(args)
+	if !ok {
+		return ErrType
+	}
+	if len(a) == 0 {
+		return nothing
+	}
+	keys := make([]JSON, len(a))
+	for i, v := range a {
+		keys[i], ok = keyPath(v, key)
+		if !ok {
+			return ErrType
+		}
+	}
+	if isNothing(minMaxArray(keys, minArith, minString)) {
+		return ErrType
+	}
+	best := 0
+	for i := 1; i < len(a); i++ {
+		if relation(keys[i], keys[best], intf, floatf, stringf) == true {
+			best = i
+		}
+	}
+	return a[best]
+}
+
+// typeTest returns the body of a function that checks whether its argument has JSON type t.
+func typeTest(t jsType) func([]JSON) JSON {
+	return func(args []JSON) JSON {
+		return jsonType(args[0]) == t
+	}
+}
+
+// stringFunc returns the body of a function that applies f to its string argument.
+func stringFunc(f func(string) string) func([]JSON) JSON {
+	return func(args []JSON) JSON {
+		s, ok := args[0].(string)
+		if !ok {
+			return ErrType
+		}
+		return f(s)
+	}
+}
+
+// trimFunc returns the body of a trim function, which removes white space from a string using space,
+// or instead the characters in an optional second argument using cut.
+func trimFunc(space func(string) string, cut func(string, string) string) func([]JSON) JSON {
+	return func(args []JSON) JSON {
+		if len(args) == 1 {
+			if s, ok := args[0].(string); ok {
+				return space(s)
+			}
+			return ErrType
+		}
+		s, chars, ok := stringArgs(args)
+		if !ok {
+			return ErrType
+		}
+		return cut(s, chars)
+	}
+}
+
+// arrayKeyArgs returns the array and key path arguments of a function such as sort, and true if they are valid.
+// The key is optional, defaulting to "" (the element itself).
+func arrayKeyArgs(args []JSON) ([]JSON, string, bool) {
+	a, ok := args[0].([]JSON)
+	if !ok {
+		return nil, "", false
+	}
+	if len(args) == 1 {
+		return a, "", true
+	}
+	key, ok := args[1].(string)
+	return a, key, ok
+}
+
+// keyPath returns the value selected from v by path, a sequence of member names or array indices separated by dots (eg, "a.b.0"),
+// and true if it exists. An empty path selects v itself.
+func keyPath(v JSON, path string) (JSON, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch a := v.(type) {
+		case []JSON:
+			n, err := strconv.Atoi(key)
+			if err != nil || n < 0 || n >= len(a) {
+				return nil, false
+			}
+			v = a[n]
+		case map[string]JSON:
+			el, ok := a[key]
+			if !ok {
+				return nil, false
+			}
+			v = el
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// flattenArray appends the elements of a to out, replacing arrays by their elements down to the given depth.
+func flattenArray(out []JSON, a []JSON, depth int) []JSON {
+	for _, v := range a {
+		if el, ok := v.([]JSON); ok && depth > 0 {
+			out = flattenArray(out, el, depth-1)
+		} else {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func arithArrayOp(a []JSON, f func(int, float64, float64) float64) JSON {
 	if len(a) == 0 {
 		return nil
@@ -318,7 +1473,7 @@ func minMaxArray(a []JSON, arithf func(int, float64, float64) float64, stringf f
 	if len(a) == 0 {
 		return nil
 	}
-	switch a[0].(type) {
+	switch number(a[0]).(type) {
 	case int, int64, float64:
 		return arithArrayOp(a, arithf)
 	case string:
@@ -350,7 +1505,7 @@ func minString(index int, min, el string) string {
 }
 
 func maxString(index int, max, el string) string {
-	if index == 0 || el < max {
+	if index == 0 || el > max {
 		return el
 	}
 	return max
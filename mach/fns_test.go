@@ -3,14 +3,16 @@ package mach
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/forsyth/jsonpath/paths"
 )
 
 type funcTest struct {
 	expr   string // expression with a single call or array of calls
-	expect string // expected result
+	expect string // expected result, or "nothing"
 }
 
 var funcTests = []funcTest{
@@ -20,33 +22,207 @@ var funcTests = []funcTest{
 	{"ceil(1.5)", "2"},
 	{"ceil(2)", "2"},
 	{"ceil(-1.75)", "-1"},
+	{"clamp(5, 1, 3)", "3"},
+	{"clamp(-5, 1, 3)", "1"},
+	{"clamp(2.5, 1, 3)", "2.5"},
+	{"clamp(2, 3, 1)", "nothing"},
+	{"clamp('2', 1, 3)", "nothing"},
 	{"contains('subject stringy', 'ject')", "true"},
 	{"contains('subject stringy', 'queen')", "false"},
 	{"contains([1, 2, 3.5, 'hat', 6], 'hat')", "true"},
+	{"count_if([true, false, 1, 0, 'x', ''])", "3"},
+	{"count_if([{'ok': true}, {'ok': false}, {}, {'ok': 1}], 'ok')", "2"},
+	{"count_if('abc')", "nothing"},
+	{"date_add(0, 1.5, 'h')", "5400000"},
+	{"date_add('2024-02-28T23:00:00Z', 2, 'h')", "1709168400000"},
+	{"date_add(0, -1, 'w')", "-604800000"},
+	{"date_add(0, 1, 'fortnight')", "nothing"},
+	{"date_add(8640000000000000, 1, 'd')", "nothing"},
+	{"date_diff('2024-01-02T00:00:00+01:00', '2024-01-01T00:00:00Z', 'h')", "23"},
+	{"date_diff(90000, 0, 'm')", "1.5"},
+	{"date_diff(1500, 500, 'ms')", "1000"},
+	{"date_diff('yesterday', 0, 'd')", "nothing"},
+	{"distinct([1, 2, 1, 'a', 2.0, 'a', [3], [3]])", "[1,2,\"a\",[3]]"},
 	{"ends_with('and another thing', 'other thing')", "true"},
 	{"ends_with('christmas', 'dinner')", "false"},
+	{"exp(0)", "1"},
+	{"exp(1000)", "nothing"},
+	{"first([3, 2, 1])", "3"},
+	{"first([])", "nothing"},
+	{"flatten([1, [2, [3, [4]]], 5])", "[1,2,[3,[4]],5]"},
+	{"flatten([1, [2, [3, [4]]], 5], 2)", "[1,2,3,[4],5]"},
+	{"flatten([1, [2, [3, [4]]], 5], 0)", "[1,[2,[3,[4]]],5]"},
+	{"flatten([1, [2]], -1)", "nothing"},
 	{"floor(-1.75)", "-2"},
 	{"floor(1.75)", "1"},
 	{"floor(1)", "1"},
-	// without object literals, keys can't yet be tested this way: needs to be part of path test
+	{"format_time(0)", "\"1970-01-01T00:00:00Z\""},
+	{"format_time(1709254800123)", "\"2024-03-01T01:00:00.123Z\""},
+	{"format_time('2024-03-01T02:00:00+01:00', '2006-01-02 15:04')", "\"2024-03-01 01:00\""},
+	{"format_time('march')", "nothing"},
+	{"from_entries([['b', 1], ['a', [2]], ['b', 3]])", "{\"a\":[2],\"b\":3}"},
+	{"from_entries([])", "{}"},
+	{"from_entries([['a']])", "nothing"},
+	{"from_entries([[1, 'a']])", "nothing"},
+	{"get({'a': {'b': [10, 20]}}, 'a.b.1')", "20"},
+	{"get({'a': null}, 'a', 5)", "null"},
+	{"get({'a': 1}, 'b', 'none')", "\"none\""},
+	{"get({'a': 1}, 'b')", "nothing"},
+	{"get({'a': 1}, 1)", "nothing"},
+	{"group_by([{'k': 'a', 'v': 1}, {'k': 'b', 'v': 2}, {'k': 'a', 'v': 3}, {'v': 4}], 'k')", "{\"a\":[{\"k\":\"a\",\"v\":1},{\"k\":\"a\",\"v\":3}],\"b\":[{\"k\":\"b\",\"v\":2}]}"},
+	{"group_by([{'n': 1}, {'n': 1.5}, {'n': 1}], 'n')", "{\"1\":[{\"n\":1},{\"n\":1}],\"1.5\":[{\"n\":1.5}]}"},
+	{"group_by([[1]], '')", "nothing"},
+	{"has({'a': null}, 'a')", "true"},
+	{"has({'a': null}, 'b')", "false"},
+	{"has([1, 2], 1)", "true"},
+	{"has([1, 2], 2)", "false"},
+	{"has('ab', 'a')", "nothing"},
+	{"index_of('hello, sailor', 'sail')", "7"},
+	{"index_of('¿qué?', 'é')", "3"},
+	{"index_of('hello', 'x')", "-1"},
+	{"index_of([1, 'two', 3], 'two')", "1"},
+	{"is_array([1])", "true"},
+	{"is_array({})", "false"},
+	{"is_boolean(false)", "true"},
+	{"is_boolean(0)", "false"},
+	{"is_integer(3)", "true"},
+	{"is_integer(3.0)", "true"},
+	{"is_integer(3.5)", "false"},
+	{"is_integer('3')", "false"},
+	{"is_null(null)", "true"},
+	{"is_null(0)", "false"},
+	{"is_number(1.5)", "true"},
+	{"is_number('1.5')", "false"},
+	{"is_number(true)", "false"},
+	{"is_object({})", "true"},
+	{"is_object([])", "false"},
+	{"is_object(null)", "false"},
+	{"is_string('')", "true"},
+	{"is_string([''])", "false"},
+	{"join(['a', 'b', 'c'], ', ')", "\"a, b, c\""},
+	{"join([], '-')", "\"\""},
+	{"join(['a', 1], '-')", "nothing"},
+	{"keys({'b': 1, 'a': [2], c: {}})", "[\"a\",\"b\",\"c\"]"},
+	{"last([3, 2, 1])", "1"},
+	{"last([])", "nothing"},
 	{"length('hello, sailor')", "13"},
 	{"length([1, 2, 3, 4, 5])", "5"},
 	{"length([])", "0"},
 	{"length(2.5)", "null"},
+	{"log(1)", "0"},
+	{"log(0)", "nothing"},
+	{"log(-1)", "nothing"},
+	{"log10(1000)", "3"},
+	{"lower('Hello, Sailor')", "\"hello, sailor\""},
 	{"max(1, 2, 3, 5, 4)", "5"},
 	{"max([-1, -2, -3, 5, 4])", "5"},
+	{"max(['pear', 'apple', 'fig'])", "\"pear\""},
+	{"max_by([{'n': 'a', 'v': 2}, {'n': 'b', 'v': 5}, {'n': 'c', 'v': 5}], 'v')", "{\"n\":\"b\",\"v\":5}"},
+	{"max_by([], 'v')", "nothing"},
+	{"merge({'a': 1, 'b': 2}, {'b': 3}, {'c': {}})", "{\"a\":1,\"b\":3,\"c\":{}}"},
+	{"merge({'a': 1}, [])", "nothing"},
 	{"min(5, 3, 1, -1, 0)", "-1"},
 	{"min([5, 3.5, 1, -1.5, 0])", "-1.5"},
+	{"min(['pear', 'apple', 'fig'])", "\"apple\""},
+	{"min_by([{'n': 'a', 'v': 2}, {'n': 'b', 'v': -1.5}], 'v')", "{\"n\":\"b\",\"v\":-1.5}"},
+	{"min_by([{'v': 2}, {'v': 'x'}], 'v')", "nothing"},
+	{"pad('7', 3, '0')", "\"007\""},
+	{"pad('ab', -4)", "\"ab  \""},
+	{"pad('abcde', 3)", "\"abcde\""},
+	{"pad('ab', 4, '--')", "nothing"},
+	{"parse_time('1970-01-01T00:00:01.5Z')", "1500"},
+	{"parse_time('2024-03-01T02:00:00+01:00')", "1709254800000"},
+	{"parse_time('01/03/2024', '02/01/2006')", "1709251200000"},
+	{"parse_time('2024-03-01')", "nothing"},
+	{"parse_time(0)", "nothing"},
+	{"pick({'a': 1, 'b': 2, 'c': 3}, 'c', 'a', 'x')", "{\"a\":1,\"c\":3}"},
+	{"pick({'a': 1, 'b': 2, 'c': 3}, ['a', 'b'])", "{\"a\":1,\"b\":2}"},
+	{"pick({'a': 1}, 1)", "nothing"},
+	{"pow(2, 10)", "1024"},
+	{"pow(-3, 3)", "-27"},
+	{"pow(2, -1)", "0.5"},
+	{"pow(2, 0.5)", "1.4142135623730951"},
+	{"pow(2, 63)", "nothing"},
+	{"pow(-2, 63)", "-9223372036854775808"},
+	{"pow(10, 400.0)", "nothing"},
+	{"pow(-8, 0.5)", "nothing"},
 	{"prod([])", "null"},
 	{"prod([1, 2, 3, 4, 5])", "120"},
+	{"repeat('ab', 3)", "\"ababab\""},
+	{"repeat('ab', 0)", "\"\""},
+	{"repeat('ab', -1)", "nothing"},
+	{"repeat('ab', 1000000000000000)", "nothing"},
+	{"replace('a1b22c333', '[0-9]+', '#')", "\"a#b#c#\""},
+	{"replace('John Smith', '(\\\\w+) (\\\\w+)', '$2, $1')", "\"Smith, John\""},
+	{"replace('abc', '(', '')", "nothing"},
+	{"reverse([1, 'b', [3]])", "[[3],\"b\",1]"},
+	{"reverse([])", "[]"},
+	{"round(2.5)", "3"},
+	{"round(-2.5)", "-2"},
+	{"round(-2.6)", "-3"},
+	{"round(7)", "7"},
+	{"round(3.14159, 2)", "3.14"},
+	{"round(1234.5, -2)", "1200"},
+	{"round(1234, -2)", "1200"},
+	{"round(1.5, 0.5)", "nothing"},
+	{"round('1.5')", "nothing"},
+	{"sign(-7)", "-1"},
+	{"sign(0)", "0"},
+	{"sign(2.5)", "1"},
+	{"sign('x')", "nothing"},
+	{"slice([1, 2, 3, 4, 5], 1)", "[2,3,4,5]"},
+	{"slice([1, 2, 3, 4, 5], 1, -1)", "[2,3,4]"},
+	{"slice([1, 2, 3, 4, 5], null, null, 2)", "[1,3,5]"},
+	{"slice([1, 2, 3, 4, 5], -2, null, -1)", "[4,3,2,1]"},
+	{"slice([1, 2, 3], 5)", "[]"},
+	{"slice([1, 2, 3], 0, 3, 0)", "[]"},
+	{"slice([1, 2, 3], 0.5)", "nothing"},
+	{"slice('abc', 1)", "nothing"},
+	{"sort([3, 1.5, -2, 10])", "[-2,1.5,3,10]"},
+	{"sort(['pear', 'apple', 'Fig'])", "[\"Fig\",\"apple\",\"pear\"]"},
+	{"sort([{'n': 'b', 'a': {'x': 2}}, {'n': 'c', 'a': {'x': 1}}, {'n': 'a', 'a': {'x': 2}}], 'a.x')", "[{\"a\":{\"x\":1},\"n\":\"c\"},{\"a\":{\"x\":2},\"n\":\"b\"},{\"a\":{\"x\":2},\"n\":\"a\"}]"},
+	{"sort([[2, 'b'], [1, 'a']], '0')", "[[1,\"a\"],[2,\"b\"]]"},
+	{"sort([1, 'a'])", "nothing"},
+	{"sort([{'n': 1}, {}], 'n')", "nothing"},
+	{"split('a,b,,c', ',')", "[\"a\",\"b\",\"\",\"c\"]"},
+	{"split('añb', '')", "[\"a\",\"ñ\",\"b\"]"},
+	{"sqrt(16)", "4"},
+	{"sqrt(2)", "1.4142135623730951"},
+	{"sqrt(-1)", "nothing"},
 	{"starts_with('christmas', 'chr')", "true"},
 	{"starts_with('christmas', 'all hallows')", "false"},
+	{"substring('ABC-123', 0, 3)", "\"ABC\""},
+	{"substring('ABC-123', 4)", "\"123\""},
+	{"substring('ABC-123', -3)", "\"123\""},
+	{"substring('naïve', 2, 3)", "\"ï\""},
+	{"substring('ABC', 2, 1)", "\"\""},
+	{"substring('ABC', 1, 100)", "\"BC\""},
+	{"substring('ABC', 1.5)", "nothing"},
 	{"sum([])", "0"},
 	{"sum([1, 2, 3, 4, 5.55])", "15.55"},
+	{"to_entries({'b': 1, 'a': [2]})", "[[\"a\",[2]],[\"b\",1]]"},
+	{"to_entries([1])", "nothing"},
 	{"to_number(1.75)", "1.75"},
 	//	{"to_number('apple')", ""},
 	{"to_number('1.75e5')", "175000"},
 	{"tokenize('J. R. R. Tolkien', '\\\\s+')", "[\"J.\",\"R.\",\"R.\",\"Tolkien\"]"},
+	{"trim('  both ends\\t')", "\"both ends\""},
+	{"trim('xxmiddlexx', 'x')", "\"middle\""},
+	{"trim_left('  left')", "\"left\""},
+	{"trim_left('007', '0')", "\"7\""},
+	{"trim_right('right  ')", "\"right\""},
+	{"trim_right('1.500', '0')", "\"1.5\""},
+	{"trim(1)", "nothing"},
+	{"trunc(-1.75)", "-1"},
+	{"trunc(1.75)", "1"},
+	{"trunc(3)", "3"},
+	{"[type(null), type(true), type(1), type(2.5), type('s'), type([]), type({})]", "[\"null\",\"boolean\",\"number\",\"number\",\"string\",\"array\",\"object\"]"},
+	{"upper('Hello, Sailor')", "\"HELLO, SAILOR\""},
+	{"values({'b': 1, 'a': [2], c: {}})", "[[2],1,{}]"},
+	{"values('abc')", "nothing"},
+	{"zip([1, 2, 3], ['a', 'b'])", "[[1,\"a\"],[2,\"b\"]]"},
+	{"zip([1], [2], [3])", "[[1,2,3]]"},
+	{"zip([1], 2)", "nothing"},
 }
 
 func TestFunctions(t *testing.T) {
@@ -62,7 +238,7 @@ func TestFunctions(t *testing.T) {
 			if err != nil {
 				t.Fatalf("function test %d: %q: %s", i, ft.expr, err)
 			}
-			got = jsonString(ret)
+			got = resultString(ret)
 		case paths.OpArray:
 			a := expr.(*paths.Inner)
 			array := []JSON{}
@@ -87,6 +263,14 @@ func TestFunctions(t *testing.T) {
 	}
 }
 
+// resultString returns a function's result as JSON, or "nothing" if it is an error value.
+func resultString(v JSON) string {
+	if isNothing(v) {
+		return "nothing"
+	}
+	return jsonString(v)
+}
+
 func testcall(kids []paths.Expr) (JSON, error) {
 	if len(kids) == 0 {
 		return nil, errors.New("no identifier child in call")
@@ -99,7 +283,7 @@ func testcall(kids []paths.Expr) (JSON, error) {
 	if err != nil {
 		return nil, fmt.Errorf("call to %s: %w", nm.Name, err)
 	}
-	return call(nm.Name, args)
+	return (&machine{prog: &Program{functions: functions, keyOrder: SortedKeys}}).call(nm.Name, args)
 }
 
 func collect(kids []paths.Expr, args []JSON) ([]JSON, error) {
@@ -111,6 +295,10 @@ func collect(kids []paths.Expr, args []JSON) ([]JSON, error) {
 			args = append(args, t.Val)
 		case *paths.StringLeaf:
 			args = append(args, t.Val)
+		case *paths.BoolLeaf:
+			args = append(args, t.Val)
+		case *paths.NullLeaf:
+			args = append(args, nil)
 		case *paths.Inner:
 			switch t.Op {
 			case paths.OpNeg:
@@ -128,6 +316,16 @@ func collect(kids []paths.Expr, args []JSON) ([]JSON, error) {
 					return nil, err
 				}
 				args = append(args, els)
+			case paths.OpObject:
+				els, err := collect(t.Kids, []JSON{})
+				if err != nil {
+					return nil, err
+				}
+				obj := make(map[string]JSON)
+				for i := 0; i < len(els); i += 2 {
+					obj[els[i].(string)] = els[i+1]
+				}
+				args = append(args, obj)
 			default:
 				return nil, fmt.Errorf("unexpected op %#v in argument", t.Op)
 			}
@@ -137,3 +335,101 @@ func collect(kids []paths.Expr, args []JSON) ([]JSON, error) {
 	}
 	return args, nil
 }
+
+// TestUserFunctions checks functions added by WithFunctions and Register, and the compile-time checks on calls.
+func TestUserFunctions(t *testing.T) {
+	double := NewFunction(1, func(args []JSON) JSON {
+		return cvf(args[0]) * 2
+	})
+	regLock.Lock()
+	saved := registered
+	regLock.Unlock()
+	t.Cleanup(func() {
+		regLock.Lock()
+		registered = saved
+		regLock.Unlock()
+	})
+	Register("test_double", double)
+	tests := []struct {
+		path   string
+		opts   []Option
+		expect string // results as JSON, or error text
+	}{
+		{"$[?(twice(@) > 4)]", []Option{WithFunctions(map[string]Function{"twice": double})}, `[3]`},
+		{"$[?(test_double(@) > 4)]", nil, `[3]`},
+		{"$[?(twice(@) > 4)]", nil, `call of unknown function: twice`},
+		{"$[?(test_double(@, 1) > 4)]", nil, `test_double: wrong argument count: need 1, got 2`},
+		{"$[?(substring(@))]", nil, `substring: wrong argument count: need 2 to 3, got 1`},
+		{"$[?(@ && pad(@, 1, ' ', 2))]", nil, `pad: wrong argument count: need 2 to 3, got 4`},
+		{"$[?(trim())]", nil, `trim: wrong argument count: need 1 to 2, got 0`},
+		{"$[?(zip())]", nil, `zip: wrong argument count: need at least 1, got 0`},
+		{"$[?(sort(@, 'a', 'b'))]", nil, `sort: wrong argument count: need 1 to 2, got 3`},
+		{"$[?(slice())]", nil, `slice: wrong argument count: need 1 to 4, got 0`},
+		{"$[?(round(@.n, 1, 2, 3))]", nil, `round: wrong argument count: need 1 to 2, got 4`},
+		{"$[?(parse_time())]", nil, `parse_time: wrong argument count: need 1 to 2, got 0`},
+		{"$[?(format_time(@, 'x', 'y'))]", nil, `format_time: wrong argument count: need 1 to 2, got 3`},
+		{"$[?(get(@))]", nil, `get: wrong argument count: need 2 to 3, got 1`},
+		{"$[?(merge())]", nil, `merge: wrong argument count: need at least 1, got 0`},
+		{"$[?(pick())]", nil, `pick: wrong argument count: need at least 1, got 0`},
+		{"$[?(abs(@) > 2)]", []Option{WithFunctions(map[string]Function{"abs": double})}, `[2,3]`},
+		{"$[?(abs(@) > 2)]", nil, `[3]`},
+	}
+	for i, ut := range tests {
+		path, err := paths.ParsePath(ut.path)
+		if err != nil {
+			t.Fatalf("user function test %d: %s: parse: %s", i, ut.path, err)
+		}
+		prog, err := Compile(path, ut.opts...)
+		if err != nil {
+			if err.Error() != ut.expect {
+				t.Errorf("user function test %d: %s: got error %q, expected %s", i, ut.path, err, ut.expect)
+			}
+			var ce *CallError
+			if !errors.As(err, &ce) || !strings.HasPrefix(ut.path[ce.Offset:], ce.Name+"(") {
+				t.Errorf("user function test %d: %s: got %#v, expected *CallError locating the call", i, ut.path, err)
+			}
+			continue
+		}
+		vals, err := prog.Run([]JSON{1.0, 2.0, 3.0})
+		if err != nil {
+			t.Errorf("user function test %d: %s: run: %s", i, ut.path, err)
+			continue
+		}
+		if got := jsonString(vals); got != ut.expect {
+			t.Errorf("user function test %d: %s: got %s, expected %s", i, ut.path, got, ut.expect)
+		}
+	}
+}
+
+// TestClock checks that now() takes the time from the clock given by WithClock.
+func TestClock(t *testing.T) {
+	reads := 0
+	clock := func() time.Time {
+		reads++
+		return time.Date(2024, time.March, 1, 12, 0, 0, reads-1, time.UTC)
+	}
+	zero := func() time.Time {
+		reads++
+		return time.Time{}
+	}
+	tests := []struct {
+		path   string
+		clock  func() time.Time
+		expect string
+	}{
+		{"$[?(parse_time(@.at) > now() - 86400000)].id", clock, `[2,3]`},
+		{"$[?(date_diff(now(), @.at, 'd') >= 1)].id", clock, `[1]`},
+		{"$[(now() == 1709294400000 ? 0 : 1)].id", clock, `[1]`},
+		{"$[?(now() < parse_time(@.at))].id", zero, `[1,2,3]`},
+	}
+	const doc = `[{"id": 1, "at": "2024-02-29T11:59:59Z"}, {"id": 2, "at": "2024-02-29T12:00:01Z"}, {"id": 3, "at": "2024-03-01T13:00:00+02:00"}]`
+	for i, ct := range tests {
+		reads = 0
+		if got := runQuery(ct.path, doc, t, WithClock(ct.clock)); got != ct.expect {
+			t.Errorf("clock test %d: %s: got %s, expected %s", i, ct.path, got, ct.expect)
+		}
+		if reads != 1 {
+			t.Errorf("clock test %d: %s: clock read %d times, expected once", i, ct.path, reads)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package mach
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrIRegexp = errors.New("invalid I-Regexp")
+)
+
+// iregexp converts a pattern in the I-Regexp syntax of RFC 9485 (used by RFC 9535's match and search)
+// to the syntax of Go's regexp package, or returns ErrIRegexp if it is not a valid I-Regexp,
+// including when it uses Go's own extensions, such as (?i), (?:...), \A, \d or lazy quantifiers.
+// The differences in the valid patterns are small: "." does not match \n or \r, "^" and "$" are ordinary characters,
+// and the only escapes are single-character escapes and \p{...} or \P{...}.
+// Go's regexp.Compile checks what the grammar cannot (eg, that a range's bounds are in order).
+func iregexp(pat string) (string, error) {
+	p := &ireParser{s: pat}
+	p.regexp()
+	if p.bad || p.i < len(p.s) {
+		return "", ErrIRegexp
+	}
+	return p.sb.String(), nil
+}
+
+// ireParser parses an I-Regexp, writing the equivalent Go syntax to sb.
+type ireParser struct {
+	s   string          // the pattern
+	i   int             // offset of the next character in s
+	sb  strings.Builder // Go syntax
+	bad bool            // s is not an I-Regexp
+}
+
+// ireEOF is the character at the end of the pattern.
+const ireEOF = -1
+
+// peek returns the next character, without consuming it.
+func (p *ireParser) peek() rune {
+	if p.i >= len(p.s) {
+		return ireEOF
+	}
+	r, _ := utf8.DecodeRuneInString(p.s[p.i:])
+	return r
+}
+
+// next consumes and returns the next character.
+func (p *ireParser) next() rune {
+	if p.i >= len(p.s) {
+		return ireEOF
+	}
+	r, w := utf8.DecodeRuneInString(p.s[p.i:])
+	p.i += w
+	return r
+}
+
+// i-regexp = branch *( "|" branch )
+func (p *ireParser) regexp() {
+	p.branch()
+	for !p.bad && p.peek() == '|' {
+		p.next()
+		p.sb.WriteByte('|')
+		p.branch()
+	}
+}
+
+// branch = *piece
+// piece = atom [ quantifier ]
+func (p *ireParser) branch() {
+	for !p.bad {
+		switch p.peek() {
+		case ireEOF, '|', ')':
+			return
+		}
+		p.atom()
+		p.quantifier()
+	}
+}
+
+// atom = NormalChar / charClass / ( "(" i-regexp ")" )
+// charClass = "." / SingleCharEsc / charClassEsc / charClassExpr
+func (p *ireParser) atom() {
+	switch c := p.next(); c {
+	case '(':
+		p.sb.WriteString("(?:")
+		p.regexp()
+		if p.next() != ')' {
+			p.bad = true
+			return
+		}
+		p.sb.WriteByte(')')
+	case '.':
+		p.sb.WriteString(`[^\n\r]`)
+	case '[':
+		p.class()
+	case '\\':
+		p.escape(true)
+	case ')', '*', '+', '?', ']', '{', '|', '}':
+		p.bad = true
+	case '^', '$':
+		// ordinary characters
+		p.sb.WriteByte('\\')
+		p.sb.WriteRune(c)
+	default:
+		p.sb.WriteRune(c)
+	}
+}
+
+// quantifier = ( "*" / "+" / "?" ) / range-quantifier
+// range-quantifier = "{" QuantExact [ "," [ QuantExact ] ] "}"
+func (p *ireParser) quantifier() {
+	switch p.peek() {
+	case '*', '+', '?':
+		p.sb.WriteRune(p.next())
+	case '{':
+		p.next()
+		p.sb.WriteByte('{')
+		if !p.digits() {
+			p.bad = true
+			return
+		}
+		if p.peek() == ',' {
+			p.next()
+			p.sb.WriteByte(',')
+			p.digits()
+		}
+		if p.next() != '}' {
+			p.bad = true
+			return
+		}
+		p.sb.WriteByte('}')
+	}
+}
+
+// digits copies QuantExact = 1*%x30-39, returning false if there are none.
+func (p *ireParser) digits() bool {
+	n := 0
+	for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+		p.sb.WriteRune(p.next())
+		n++
+	}
+	return n > 0
+}
+
+// escape translates the escape following "\": a SingleCharEsc, or a charClassEsc if cat is true.
+// SingleCharEsc = "\" ( %x28-2B / "-" / "." / "?" / %x5B-5E / %s"n" / %s"r" / %s"t" / %x7B-7D )
+// charClassEsc = ( %s"\p{" / %s"\P{" ) charProp "}"
+func (p *ireParser) escape(cat bool) {
+	switch c := p.next(); c {
+	case '(', ')', '*', '+', '-', '.', '?', '[', '\\', ']', '^', '{', '|', '}', 'n', 'r', 't':
+		p.sb.WriteByte('\\')
+		p.sb.WriteRune(c)
+	case 'p', 'P':
+		if !cat || p.next() != '{' {
+			p.bad = true
+			return
+		}
+		end := strings.IndexByte(p.s[p.i:], '}')
+		if end < 0 || !ireCategories[p.s[p.i:p.i+end]] {
+			p.bad = true
+			return
+		}
+		p.sb.WriteByte('\\')
+		p.sb.WriteRune(c)
+		p.sb.WriteString(p.s[p.i-1 : p.i+end+1])
+		p.i += end + 1
+	default:
+		p.bad = true
+	}
+}
+
+// class translates the rest of a character class expression, after "[".
+// charClassExpr = "[" [ "^" ] ( "-" / CCE1 ) *CCE1 [ "-" ] "]"
+func (p *ireParser) class() {
+	p.sb.WriteByte('[')
+	if p.peek() == '^' {
+		p.next()
+		p.sb.WriteByte('^')
+	}
+	if p.peek() == '-' {
+		p.next()
+		p.sb.WriteString(`\-`)
+	} else {
+		p.cce1()
+	}
+	for !p.bad {
+		switch p.peek() {
+		case ']':
+			p.next()
+			p.sb.WriteByte(']')
+			return
+		case '-':
+			// only at the end
+			p.next()
+			if p.peek() != ']' {
+				p.bad = true
+				return
+			}
+			p.sb.WriteString(`\-`)
+		default:
+			p.cce1()
+		}
+	}
+}
+
+// CCE1 = ( CCchar [ "-" CCchar ] ) / charClassEsc
+func (p *ireParser) cce1() {
+	if p.peek() == '\\' && p.i+1 < len(p.s) && (p.s[p.i+1] == 'p' || p.s[p.i+1] == 'P') {
+		p.next()
+		p.escape(true)
+		return
+	}
+	p.ccchar()
+	if p.peek() == '-' && p.i+1 < len(p.s) && p.s[p.i+1] != ']' {
+		p.next()
+		p.sb.WriteByte('-')
+		p.ccchar()
+	}
+}
+
+// CCchar = ( %x00-2C / %x2E-5A / %x5E-D7FF / %xE000-10FFFF ) / SingleCharEsc
+func (p *ireParser) ccchar() {
+	switch c := p.next(); c {
+	case ireEOF, '-', '[', ']':
+		p.bad = true
+	case '\\':
+		p.escape(false)
+	default:
+		if c == '^' {
+			// not special here, but it is at the start of Go's classes
+			p.sb.WriteByte('\\')
+		}
+		p.sb.WriteRune(c)
+	}
+}
+
+// ireCategories are the names of the Unicode general categories allowed in \p{...} and \P{...}.
+var ireCategories = map[string]bool{
+	"L": true, "Ll": true, "Lm": true, "Lo": true, "Lt": true, "Lu": true,
+	"M": true, "Mc": true, "Me": true, "Mn": true,
+	"N": true, "Nd": true, "Nl": true, "No": true,
+	"P": true, "Pc": true, "Pd": true, "Pe": true, "Pf": true, "Pi": true, "Po": true, "Ps": true,
+	"Z": true, "Zl": true, "Zp": true, "Zs": true,
+	"S": true, "Sc": true, "Sk": true, "Sm": true, "So": true,
+	"C": true, "Cc": true, "Cf": true, "Cn": true, "Co": true,
+}
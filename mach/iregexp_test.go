@@ -0,0 +1,63 @@
+package mach
+
+import (
+	"testing"
+)
+
+// ireTests give I-Regexps and their translation to Go's syntax, or "!" if they are not valid I-Regexps.
+var ireTests = []struct {
+	pat    string
+	expect string
+}{
+	{`abc`, `abc`},
+	{`a.c`, `a[^\n\r]c`},
+	{`^a$`, `\^a\$`},
+	{`(ab|c)*d+e?`, `(?:ab|c)*d+e?`},
+	{`a{2}b{1,}c{0,3}`, `a{2}b{1,}c{0,3}`},
+	{`\p{Lu}\P{Nd}\.\n\\`, `\p{Lu}\P{Nd}\.\n\\`},
+	{`[a-z0-9_]`, `[a-z0-9_]`},
+	{`[^-a]`, `[^\-a]`},
+	{`[a-]`, `[a\-]`},
+	{`[\p{L}\]^]`, `[\p{L}\]\^]`},
+	{`[\--\.]`, `[\--\.]`},
+	{`é+`, `é+`},
+	{``, ``},
+	{`(?i)abc`, `!`},
+	{`(?:abc)`, `!`},
+	{`\Aabc\z`, `!`},
+	{`a*?`, `!`},
+	{`a+?`, `!`},
+	{`a{1,2}?`, `!`},
+	{`\d+`, `!`},
+	{`\w`, `!`},
+	{`\b`, `!`},
+	{`\$`, `!`},
+	{`\p{Greek}`, `!`},
+	{`\p{Cs}`, `!`},
+	{`[\d]`, `!`},
+	{`[[:alpha:]]`, `!`},
+	{`[a[b]`, `!`},
+	{`[]`, `!`},
+	{`[a-b-c]`, `!`},
+	{`[a`, `!`},
+	{`(a`, `!`},
+	{`a)`, `!`},
+	{`a{,2}`, `!`},
+	{`a{2`, `!`},
+	{`*a`, `!`},
+	{`a**`, `!`},
+	{`a\`, `!`},
+}
+
+// TestIRegexp checks the translation of I-Regexps, and the rejection of other patterns, including Go's extensions.
+func TestIRegexp(t *testing.T) {
+	for i, it := range ireTests {
+		got, err := iregexp(it.pat)
+		if err != nil {
+			got = "!"
+		}
+		if got != it.expect {
+			t.Errorf("i-regexp %d: %s: got %s, expected %s", i, it.pat, got, it.expect)
+		}
+	}
+}
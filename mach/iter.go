@@ -0,0 +1,141 @@
+package mach
+
+// Iteration over the values visited by paths.OpFor and paths.OpNest loops.
+
+// iterator produces the values visited by one loop, one at a time, in the order that the loop visits them.
+// For paths.OpFor, those are the members and elements of each value in the output set when the loop started.
+// For paths.OpNest, they are each structure in the output set, followed by all the structures within it, in pre-order;
+// the order is defined in 9.1.1.8 [[Descendants]] of
+// https://www.ecma-international.org/wp-content/uploads/ECMA-357_2nd_edition_december_2005.pdf
+// Instead of recursion, the iterator keeps an explicit stack of the structures being visited.
+type iterator struct {
+	vm    *machine
+	vals  []node  // values from the output set
+	next  int     // index of next value in vals
+	stack []frame // structures whose members remain to be visited
+	nest  bool    // visit all structures within the values (paths.OpNest), not just their members
+}
+
+// frame is a structure being visited by an iterator.
+type frame struct {
+	n    *node    // the structure, to which members are linked, or nil if locations are not tracked
+	val  JSON     // the structure's value
+	keys []string // member names in KeyOrder, for an object
+	i    int      // index of next member or element
+}
+
+// newIterator returns an iterator over the values the loop visits, starting from vals.
+func newIterator(vm *machine, vals []node, nest bool) *iterator {
+	return &iterator{vm: vm, vals: vals, nest: nest}
+}
+
+// push adds structure val to the stack, to visit its members, which are linked to n when locations are tracked.
+// When nesting, only members that are themselves structures are visited, and the stack is left alone if there are none.
+func (it *iterator) push(n *node, val JSON) {
+	f := frame{n: n, val: val}
+	if obj, ok := val.(map[string]JSON); ok {
+		if it.nest {
+			f.keys = it.structures(obj)
+			if len(f.keys) == 0 {
+				return
+			}
+		} else {
+			f.keys = it.vm.prog.keyOrder.Keys(obj)
+		}
+	}
+	it.stack = append(it.stack, f)
+}
+
+// structures returns the names of the members of obj that are structures, in KeyOrder.
+// Most objects in a document have at most one, so it avoids asking the KeyOrder for the names when it can.
+func (it *iterator) structures(obj map[string]JSON) []string {
+	n := 0
+	var name string
+	for k, v := range obj {
+		if IsStructure(v) {
+			n++
+			name = k
+		}
+	}
+	switch n {
+	case 0:
+		return nil
+	case 1:
+		return []string{name}
+	}
+	// the KeyOrder might return a slice it keeps, so the names are copied, not filtered in place
+	keys := make([]string, 0, n)
+	for _, k := range it.vm.prog.keyOrder.Keys(obj) {
+		if IsStructure(obj[k]) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// member returns the next member or element of the structure in f, and true, or false if there are no more.
+func (f *frame) member(track bool) (node, bool) {
+	switch val := f.val.(type) {
+	case []JSON:
+		if f.i < len(val) {
+			f.i++
+			return f.n.child(val[f.i-1], element(f.i-1), track), true
+		}
+	case map[string]JSON:
+		if f.i < len(f.keys) {
+			k := f.keys[f.i]
+			f.i++
+			return f.n.child(val[k], member(k), track), true
+		}
+	}
+	return node{}, false
+}
+
+// step returns the next value visited by the loop, and true, or false if there are no more.
+// It returns an error if the walk for ".." goes deeper than the machine's MaxDepth.
+func (it *iterator) step() (node, bool, error) {
+	for {
+		if len(it.stack) == 0 {
+			if it.next >= len(it.vals) {
+				return node{}, false, nil
+			}
+			n := &it.vals[it.next]
+			it.next++
+			if !IsStructure(n.val) {
+				continue
+			}
+			if it.vm.track {
+				it.push(n, n.val)
+			} else {
+				it.push(nil, n.val)
+			}
+			if it.nest {
+				return *n, true, nil
+			}
+		}
+		top := &it.stack[len(it.stack)-1]
+		c, ok := top.member(it.vm.track)
+		if !ok {
+			it.stack[len(it.stack)-1] = frame{}
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		if !it.nest {
+			return c, true, nil
+		}
+		if IsStructure(c.val) {
+			if max := it.vm.limits.MaxDepth; max > 0 && len(it.stack) > max {
+				return node{}, false, &LimitError{ErrDepthLimit, max}
+			}
+			if it.vm.track {
+				// members link to a copy that does not move
+				n := new(node)
+				*n = c
+				it.push(n, c.val)
+			} else {
+				it.push(nil, c.val)
+			}
+			return c, true, nil
+		}
+	}
+}
@@ -2,8 +2,10 @@ package mach
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"strconv"
 	"strings"
 
@@ -23,19 +25,59 @@ func isString(v JSON) bool {
 }
 
 // isInt returns true if v is an integer (int might arise from len results).
+// A json.Number is an integer if it fits in an int64.
 func isInt(v JSON) bool {
-	switch v.(type) {
+	switch v := v.(type) {
 	case int, int64:
 		return true
+	case json.Number:
+		_, err := v.Int64()
+		return err == nil
 	default:
 		return false
 	}
 }
 
-// isFloat returns true if v is a floating-point value.
+// isFloat returns true if v is a floating-point value, including a json.Number that is not an int64.
 func isFloat(v JSON) bool {
-	_, ok := v.(float64)
-	return ok
+	switch v := v.(type) {
+	case float64:
+		return true
+	case json.Number:
+		_, err := v.Int64()
+		return err != nil
+	default:
+		return false
+	}
+}
+
+// number converts a json.Number (as produced by encoding/json.Decoder.UseNumber) to int64 if it fits,
+// and float64 otherwise, for operations that distinguish the Go types; other values are returned unchanged.
+func number(v JSON) JSON {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	return cvf(n)
+}
+
+// bigNums returns json.Numbers a and b as exact rationals, and true, if both are json.Numbers and at least one
+// does not fit in an int64 (eg, a large integer or a decimal fraction), so that comparing them as float64 might lose precision.
+func bigNums(a, b JSON) (*big.Rat, *big.Rat, bool) {
+	na, ok1 := a.(json.Number)
+	nb, ok2 := b.(json.Number)
+	if !ok1 || !ok2 || isInt(na) && isInt(nb) {
+		return nil, nil, false
+	}
+	x, ok1 := new(big.Rat).SetString(na.String())
+	y, ok2 := new(big.Rat).SetString(nb.String())
+	if !ok1 || !ok2 {
+		return nil, nil, false
+	}
+	return x, y, true
 }
 
 // isSlice returns true if v represents slice parameters.
@@ -47,7 +89,7 @@ func isSlice(v JSON) bool {
 // isArith returns true if v is an arithmetic type in the JS sense.
 func isArith(v JSON) bool {
 	switch v.(type) {
-	case int, int64, float64:
+	case int, int64, float64, json.Number:
 		return true
 	case bool:
 		return true // surprise!
@@ -59,7 +101,7 @@ func isArith(v JSON) bool {
 // isSimple returns true if the v is "simple" (isn't a JSON object or array).
 func isSimple(v JSON) bool {
 	switch v.(type) {
-	case bool, int, int64, float64, string:
+	case bool, int, int64, float64, json.Number, string:
 		return true
 	default:
 		return false
@@ -134,6 +176,9 @@ func badNum(s string, fp bool) bool {
 
 // eqNum returns true if numeric values a and b are equal
 func eqNum(a, b JSON) bool {
+	if x, y, ok := bigNums(a, b); ok {
+		return x.Cmp(y) == 0
+	}
 	if isFloat(a) || isFloat(b) {
 		var zero float64
 		va := cvf(a)
@@ -148,6 +193,12 @@ func eqNum(a, b JSON) bool {
 
 // eqVal returns the value of the Abstract Equality Comparison Algorithm (ECMA-262, 5.1, 11.9.3) [see notes/abstract-equality.pdf].
 func eqVal(a, b JSON) bool {
+	if l, ok := a.(nodeList); ok {
+		return eqNodes(l, b)
+	}
+	if l, ok := b.(nodeList); ok {
+		return eqNodes(l, a)
+	}
 	ta := typeOf(a)
 	tb := typeOf(b)
 	if ta != tb {
@@ -258,6 +309,107 @@ func eqVal(a, b JSON) bool {
 	}
 }
 
+// eqNodes returns true if the values selected by a query equal v, which must be another query's values or an array,
+// with the same values in the same order. There are no implicit conversions.
+func eqNodes(l nodeList, v JSON) bool {
+	switch v := v.(type) {
+	case nodeList:
+		return eqVal([]JSON(l), []JSON(v))
+	case []JSON:
+		return eqVal([]JSON(l), v)
+	default:
+		return false
+	}
+}
+
+// rfcCompare returns the result of comparison op applied to a and b, following RFC 9535 (2.3.5.2.2).
+// Unlike eqVal, there are no implicit conversions: values of different types are never equal,
+// and only numbers and strings are ordered.
+func rfcCompare(op paths.Op, a, b JSON) bool {
+	switch op {
+	case paths.OpEQ:
+		return rfcEqual(a, b)
+	case paths.OpNE:
+		return !rfcEqual(a, b)
+	case paths.OpLT:
+		return rfcLess(a, b)
+	case paths.OpLE:
+		return rfcLess(a, b) || rfcEqual(a, b)
+	case paths.OpGT:
+		return rfcLess(b, a)
+	case paths.OpGE:
+		return rfcLess(b, a) || rfcEqual(a, b)
+	default:
+		panic("unexpected comparison: " + op.GoString())
+	}
+}
+
+// rfcEqual returns true if a and b are equal by the rules of RFC 9535.
+// Two Nothings (empty results) are equal; Nothing equals no value.
+func rfcEqual(a, b JSON) bool {
+	ta := typeOf(a)
+	if ta != typeOf(b) {
+		return false
+	}
+	switch ta {
+	case Undefined, Null:
+		return true
+	case Number:
+		return eqNum(a, b)
+	case String:
+		return a.(string) == b.(string)
+	case Boolean:
+		return a.(bool) == b.(bool)
+	default:
+		switch a := a.(type) {
+		case []JSON:
+			b, ok := b.([]JSON)
+			if !ok || len(a) != len(b) {
+				return false
+			}
+			for i, ea := range a {
+				if !rfcEqual(ea, b[i]) {
+					return false
+				}
+			}
+			return true
+		case map[string]JSON:
+			b, ok := b.(map[string]JSON)
+			if !ok || len(a) != len(b) {
+				return false
+			}
+			for k, va := range a {
+				vb, ok := b[k]
+				if !ok || !rfcEqual(va, vb) {
+					return false
+				}
+			}
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// rfcLess returns true if a < b by the rules of RFC 9535: both must be numbers or both strings.
+func rfcLess(a, b JSON) bool {
+	switch {
+	case typeOf(a) == Number && typeOf(b) == Number:
+		if x, y, ok := bigNums(a, b); ok {
+			return x.Cmp(y) < 0
+		}
+		if isFloat(a) || isFloat(b) {
+			return cvf(a) < cvf(b)
+		}
+		return cvi(a) < cvi(b)
+	case isString(a) && isString(b):
+		// byte order of UTF-8 is also Unicode scalar value order
+		return a.(string) < b.(string)
+	default:
+		return false
+	}
+}
+
 // searchJSON searches an array of values (treated as a list) for an instance of value v,
 // returning f if found and !f otherwise.
 // The appropriate equality function is used for the element type.
@@ -318,6 +470,9 @@ func cvi(v JSON) int64 {
 		if n, err := v.Int64(); err == nil {
 			return n
 		}
+		if f, err := v.Float64(); err == nil {
+			return int64(f)
+		}
 		return 0
 	case paths.IntVal: // appears in Slice (via OpBounds)
 		return v.V()
@@ -351,10 +506,11 @@ func cvf(v JSON) float64 {
 		}
 		return f
 	case json.Number:
-		if f, err := v.Float64(); err == nil {
-			return f
+		f, err := strconv.ParseFloat(v.String(), 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return math.NaN()
 		}
-		return math.NaN()
+		return f // ±Inf if out of range
 	default:
 		//fmt.Printf("cvf(%#v)", v)
 		return math.NaN()
@@ -377,6 +533,8 @@ func cvb(v JSON) bool {
 		return !math.IsNaN(v) && v != 0.0 && v != -zero
 	case string:
 		return v != ""
+	case nodeList:
+		return len(v) != 0
 	case json.Number:
 		if n, err := v.Int64(); err == nil {
 			return n != 0
@@ -401,16 +559,32 @@ const (
 	String
 	Boolean
 	Object
+	Array // not returned by typeOf, since JavaScript arrays are Objects; see jsonType
 )
 
-// typeOf returns the  for a value, for use in Abstract Equality Comparison.
+// typeNames gives the name of each jsType, as returned by the type function.
+var typeNames = [...]string{
+	Undefined: "undefined",
+	Null:      "null",
+	Number:    "number",
+	String:    "string",
+	Boolean:   "boolean",
+	Object:    "object",
+	Array:     "array",
+}
+
+func (t jsType) String() string {
+	return typeNames[t]
+}
+
+// typeOf returns the JavaScript type of a value, for use in Abstract Equality Comparison.
 func typeOf(v JSON) jsType {
 	switch v.(type) {
 	case error:
 		return Undefined
 	case nil:
 		return Null
-	case int, int64, float64:
+	case int, int64, float64, json.Number:
 		return Number
 	case string:
 		return String
@@ -421,6 +595,14 @@ func typeOf(v JSON) jsType {
 	}
 }
 
+// jsonType is like typeOf, but distinguishes arrays from other objects, as JSON does.
+func jsonType(v JSON) jsType {
+	if _, ok := v.([]JSON); ok {
+		return Array
+	}
+	return typeOf(v)
+}
+
 // jsonString returns a flattened string representation of js (newlines replaced by spaces).
 func jsonString(js JSON) string {
 	var sb strings.Builder
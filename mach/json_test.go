@@ -1,9 +1,12 @@
 package mach
 
 import (
+	"encoding/json"
 	"math"
+	"strings"
 	"testing"
-	//	"github.com/forsyth/jsonpath/paths"
+
+	"github.com/forsyth/jsonpath/paths"
 )
 
 type args []JSON
@@ -115,6 +118,19 @@ var pairs = []eqTest{
 	{args{42.0, []JSON{"42.0"}}, true}, // wat!
 	{args{42.0, []JSON{42.0}}, true},   // wat!
 	{args{42.0, map[string]JSON{"42.0": true}}, false},
+
+	// json.Number, as from Decoder.UseNumber
+	{args{json.Number("42"), 42}, true},
+	{args{json.Number("42"), 42.0}, true},
+	{args{json.Number("42.5"), 42.5}, true},
+	{args{json.Number("42"), "42"}, true},
+	{args{json.Number("1e2"), 100}, true},
+	{args{json.Number("0"), false}, true},
+	{args{json.Number("42"), []JSON{42}}, true},
+	{args{json.Number("9007199254740993"), json.Number("9007199254740993")}, true},
+	{args{json.Number("12345678901234567890"), json.Number("12345678901234567891")}, false},
+	{args{json.Number("12345678901234567890"), json.Number("1.234567890123456789e19")}, true},
+	{args{json.Number("42"), map[string]JSON{}}, false},
 }
 
 // TestEquality runs through a set of tests of the abstract equality comparison algorithm (JS ==).
@@ -130,3 +146,57 @@ func TestEquality(t *testing.T) {
 		}
 	}
 }
+
+type numberTest struct {
+	path   string
+	expect string
+}
+
+// numberTests are evaluated on numberDoc, decoded with UseNumber.
+const numberDoc = `{"a": [1, 2.5, 9007199254740993, 12345678901234567890, 1e400], "n": 2, "x": 9007199254740993}`
+
+var numberTests = []numberTest{
+	{"$.a[?(@ > 2)]", `[2.5,9007199254740993,12345678901234567890,1e400]`},
+	{"$.a[?(@ == 9007199254740993)]", `[9007199254740993]`},
+	{"$.a[?(@ == $.x)]", `[9007199254740993]`},
+	{"$.a[?(@ > $.x)]", `[12345678901234567890,1e400]`},
+	{"$.a[?(@ < $.x)]", `[1,2.5]`},
+	{"$.a[?(@ * 2 == 5)]", `[2.5]`},
+	{"$.a[?(@ % 2 == 1)]", `[1,9007199254740993]`},
+	{"$.a[($.n)]", `[9007199254740993]`},
+	{"$.a[?(@ == 1 || @ == 2.5)]", `[1,2.5]`},
+	{"$.a[?(abs(@) == 1)]", `[1]`},
+	{"$.a[?(floor(@) == 2)]", `[2.5]`},
+	{"$.a[?(@ in [1, 2.5])]", `[1,2.5]`},
+	{"$.a[?(@ > 1.0e300)]", `[1e400]`},
+	{"$.a[?(to_number(@) == 1)]", `[1]`},
+}
+
+// TestNumbers checks that json.Number values behave as numbers, and are returned unchanged.
+func TestNumbers(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(numberDoc))
+	dec.UseNumber()
+	var doc JSON
+	err := dec.Decode(&doc)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	for i, nt := range numberTests {
+		path, err := paths.ParsePath(nt.path)
+		if err != nil {
+			t.Fatalf("number test %d: %s: parse: %s", i, nt.path, err)
+		}
+		prog, err := Compile(path)
+		if err != nil {
+			t.Fatalf("number test %d: %s: compile: %s", i, nt.path, err)
+		}
+		vals, err := prog.Run(doc)
+		if err != nil {
+			t.Errorf("number test %d: %s: run: %s", i, nt.path, err)
+			continue
+		}
+		if got := jsonString(vals); got != nt.expect {
+			t.Errorf("number test %d: %s: got %s, expected %s", i, nt.path, got, nt.expect)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package mach
+
+// Bounding the resources used by the machine.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrStepLimit   = errors.New("too many instructions executed")
+	ErrOutputLimit = errors.New("output set too large")
+	ErrDepthLimit  = errors.New("recursive descent too deep")
+	ErrResultLimit = errors.New("too many results")
+)
+
+// Limits bounds the resources used by one evaluation of a Program. A zero field means no limit.
+// MaxOutput and MaxResults are checked as the values selected from each member of a set are added,
+// so evaluation stops before selecting from the rest of the set.
+// They bound the sets of values selected by the path and its queries, not the values computed by expressions
+// (eg, by flatten or zip), which are bounded only by MaxSteps and the size of the document.
+type Limits struct {
+	MaxSteps   int // maximum number of machine instructions executed
+	MaxOutput  int // maximum size of the set of values selected so far, at any step of the path
+	MaxDepth   int // maximum depth of structures visited by "..", below the value where it starts
+	MaxResults int // maximum number of values in the result
+}
+
+// LimitError reports that evaluation stopped because it exceeded one of its Limits.
+// Err is ErrStepLimit, ErrOutputLimit, ErrDepthLimit or ErrResultLimit, so errors.Is can distinguish them.
+type LimitError struct {
+	Err   error // which limit
+	Limit int   // its value
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s (limit %d)", e.Err, e.Limit)
+}
+
+// Unwrap returns the error identifying the limit exceeded.
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// RunContext is like Run, but stops with ctx.Err() if ctx is cancelled or its deadline passes,
+// and with a *LimitError if the evaluation exceeds any of the given limits.
+func (p *Program) RunContext(ctx context.Context, root JSON, limits Limits) ([]JSON, error) {
+	out, err := p.run(ctx, root, false, limits)
+	if err != nil {
+		return nil, err
+	}
+	return values(out), nil
+}
+
+// checkOutput returns an error if an output set of n values exceeds the limit on its size,
+// or, when the path's last step is adding to the results, the limit on their number.
+func (vm *machine) checkOutput(n int) error {
+	if vm.limits.MaxOutput > 0 && n > vm.limits.MaxOutput {
+		return &LimitError{ErrOutputLimit, vm.limits.MaxOutput}
+	}
+	if vm.limits.MaxResults > 0 && vm.pc > vm.prog.final && len(vm.queries) == 0 && n > vm.limits.MaxResults {
+		return &LimitError{ErrResultLimit, vm.limits.MaxResults}
+	}
+	return nil
+}
+
+// checkInterval is the number of instructions between checks for cancellation.
+const checkInterval = 1024
+
+// check returns an error if the machine has exceeded the limits on instructions, output size or results, or its context is done.
+// It is called before each instruction.
+func (vm *machine) check() error {
+	vm.steps++
+	if vm.limits.MaxSteps > 0 && vm.steps > vm.limits.MaxSteps {
+		return &LimitError{ErrStepLimit, vm.limits.MaxSteps}
+	}
+	if err := vm.checkOutput(len(vm.out)); err != nil {
+		return err
+	}
+	if vm.done != nil && vm.steps%checkInterval == 0 {
+		select {
+		case <-vm.done:
+			return vm.ctx.Err()
+		default:
+		}
+	}
+	return nil
+}
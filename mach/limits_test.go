@@ -0,0 +1,96 @@
+package mach
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/forsyth/jsonpath/paths"
+)
+
+type limitTest struct {
+	path   string
+	limits Limits
+	err    error // expected error, or nil
+}
+
+var limitTests = []limitTest{
+	{"$..*..*", Limits{}, nil},
+	{"$..*..*", Limits{MaxSteps: 100}, ErrStepLimit},
+	{"$..*..*", Limits{MaxOutput: 1000}, ErrOutputLimit},
+	{"$..id", Limits{MaxDepth: 3}, nil},
+	{"$..id", Limits{MaxDepth: 2}, ErrDepthLimit},
+	{"$.items[*].id", Limits{MaxResults: 100}, ErrResultLimit},
+	{"$.items[?(@.id < 100)].id", Limits{MaxResults: 100}, nil},
+	{"$.items[?(@.id < 100)].id", Limits{MaxResults: 99}, ErrResultLimit},
+	{"$..*", Limits{MaxSteps: 1000}, ErrStepLimit},
+	{"$..*", Limits{MaxSteps: 1000, MaxResults: 10}, ErrResultLimit},
+	{"$.items[?(@.id < 100)]", Limits{MaxSteps: 1000, MaxResults: 10}, ErrResultLimit},
+	{"$.items[*]", Limits{MaxOutput: 10}, ErrOutputLimit},
+	{"$.items[*]", Limits{MaxResults: 10}, ErrResultLimit},
+	{"$.items[:10]", Limits{MaxResults: 10}, nil},
+}
+
+// TestLimits checks that each of the Limits stops evaluation with the right error.
+func TestLimits(t *testing.T) {
+	doc := benchDoc(1000)
+	for i, lt := range limitTests {
+		path, err := paths.ParsePath(lt.path)
+		if err != nil {
+			t.Fatalf("limit test %d: %s: parse: %s", i, lt.path, err)
+		}
+		prog, err := Compile(path)
+		if err != nil {
+			t.Fatalf("limit test %d: %s: compile: %s", i, lt.path, err)
+		}
+		_, err = prog.RunContext(context.Background(), doc, lt.limits)
+		if !errors.Is(err, lt.err) {
+			t.Errorf("limit test %d: %s: got error %v, expected %v", i, lt.path, err, lt.err)
+			continue
+		}
+		var le *LimitError
+		if lt.err != nil && !errors.As(err, &le) {
+			t.Errorf("limit test %d: %s: got %T, expected *LimitError", i, lt.path, err)
+		}
+	}
+}
+
+// TestCancel checks that a cancelled context stops evaluation.
+func TestCancel(t *testing.T) {
+	path, err := paths.ParsePath("$..*..*")
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	prog, err := Compile(path)
+	if err != nil {
+		t.Fatalf("compile: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = prog.RunContext(ctx, benchDoc(100), Limits{})
+	if err != context.Canceled {
+		t.Errorf("got error %v, expected %v", err, context.Canceled)
+	}
+	// too short to reach a periodic check
+	_, err = prog.RunContext(ctx, 1.0, Limits{})
+	if err != context.Canceled {
+		t.Errorf("short run: got error %v, expected %v", err, context.Canceled)
+	}
+}
+
+// TestSelectionLimit checks that a selection stops as soon as it exceeds the limit on the output set,
+// without selecting from the rest of the set.
+func TestSelectionLimit(t *testing.T) {
+	vm := &machine{prog: &Program{}, out: make([]node, 100), limits: Limits{MaxOutput: 5}}
+	calls := 0
+	err := vm.applySelection(func(src *node, acc []node) []node {
+		calls++
+		return append(acc, make([]node, 4)...)
+	})
+	if !errors.Is(err, ErrOutputLimit) {
+		t.Errorf("got error %v, expected %v", err, ErrOutputLimit)
+	}
+	if calls != 2 {
+		t.Errorf("selected from %d values, expected 2", calls)
+	}
+}
@@ -0,0 +1,166 @@
+package mach
+
+// Changing the values at the locations selected by a Program.
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+var (
+	ErrNames = errors.New("cannot change member names selected by ~")
+)
+
+// Set replaces each value selected by the Program in the document root by value,
+// returning the root (which is itself replaced if the path is just "$"), and the number of values replaced.
+// The document is changed in place. Each location is given the same value, not a copy.
+func (p *Program) Set(root JSON, value JSON) (JSON, int, error) {
+	return p.edit(root, func(JSON) (JSON, error) { return value, nil }, false)
+}
+
+// Update replaces each value selected by the Program in the document root by the result of applying f to it,
+// returning the root (which is itself replaced if the path is just "$"), and the number of values replaced.
+// The document is changed in place. If f returns an error, Update stops and returns that error,
+// with the changes made so far left in place.
+func (p *Program) Update(root JSON, f func(old JSON) (JSON, error)) (JSON, int, error) {
+	return p.edit(root, f, false)
+}
+
+// Delete removes each value selected by the Program from its containing object or array in the document root,
+// returning the root and the number of values removed.
+// The document is changed in place, and because deleting an array element shortens the array,
+// the result must be used instead of the original root (which is nil if the path is just "$").
+func (p *Program) Delete(root JSON) (JSON, int, error) {
+	return p.edit(root, nil, true)
+}
+
+// edit runs the Program to find the locations it selects in root, then replaces each value by f(value),
+// or deletes it if del is true, returning the resulting root and the number of changes.
+// The locations are changed in reverse order, deepest and last first,
+// so that deleting array elements does not disturb the locations still to be changed.
+// Member names selected by ~ are not values in the document, and cannot be changed.
+func (p *Program) edit(root JSON, f func(JSON) (JSON, error), del bool) (JSON, int, error) {
+	if p.names {
+		return root, 0, ErrNames
+	}
+	out, err := p.run(context.Background(), root, true, Limits{})
+	if err != nil {
+		return root, 0, err
+	}
+	locs := make([][]PathElement, len(out))
+	for i := range out {
+		locs[i] = out[i].elements()
+	}
+	sort.Slice(locs, func(i, j int) bool {
+		return compareLocs(locs[i], locs[j]) > 0
+	})
+	n := 0
+	for i, loc := range locs {
+		if i > 0 && compareLocs(loc, locs[i-1]) == 0 {
+			// same location selected more than once
+			continue
+		}
+		if len(loc) == 0 {
+			// $ itself
+			if del {
+				root = nil
+			} else {
+				root, err = f(root)
+				if err != nil {
+					return root, n, err
+				}
+			}
+			n++
+			continue
+		}
+		var changed bool
+		root, changed, err = editAt(root, loc, f, del)
+		if err != nil {
+			return root, n, err
+		}
+		if changed {
+			n++
+		}
+	}
+	return root, n, nil
+}
+
+// editAt applies f to the value at location loc in val, or deletes that value if del is true.
+// It returns val, which is a new array value if an element was deleted from it, and true if a change was made.
+func editAt(val JSON, loc []PathElement, f func(JSON) (JSON, error), del bool) (JSON, bool, error) {
+	at := loc[0]
+	switch c := val.(type) {
+	case map[string]JSON:
+		old, ok := c[at.Key]
+		if !ok || at.IsIndex() {
+			return val, false, nil
+		}
+		if len(loc) > 1 {
+			v, changed, err := editAt(old, loc[1:], f, del)
+			c[at.Key] = v
+			return c, changed, err
+		}
+		if del {
+			delete(c, at.Key)
+			return c, true, nil
+		}
+		v, err := f(old)
+		if err != nil {
+			return c, false, err
+		}
+		c[at.Key] = v
+		return c, true, nil
+	case []JSON:
+		if !at.IsIndex() || at.Index >= len(c) {
+			return val, false, nil
+		}
+		if len(loc) > 1 {
+			v, changed, err := editAt(c[at.Index], loc[1:], f, del)
+			c[at.Index] = v
+			return c, changed, err
+		}
+		if del {
+			return append(c[:at.Index], c[at.Index+1:]...), true, nil
+		}
+		v, err := f(c[at.Index])
+		if err != nil {
+			return c, false, err
+		}
+		c[at.Index] = v
+		return c, true, nil
+	default:
+		// the location no longer exists
+		return val, false, nil
+	}
+}
+
+// compareLocs compares two locations element by element, returning -1, 0 or 1 as a is before, the same as, or after b.
+// Array indices are compared numerically, and a location is before any location within it.
+func compareLocs(a, b []PathElement) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ea, eb := a[i], b[i]
+		switch {
+		case ea.IsIndex() && eb.IsIndex():
+			if ea.Index != eb.Index {
+				return cmp(ea.Index < eb.Index)
+			}
+		case ea.IsIndex() != eb.IsIndex():
+			return cmp(ea.IsIndex())
+		case ea.Key != eb.Key:
+			return cmp(ea.Key < eb.Key)
+		}
+	}
+	if len(a) == len(b) {
+		return 0
+	}
+	return cmp(len(a) < len(b))
+}
+
+// cmp returns -1 if less is true, and 1 otherwise.
+func cmp(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
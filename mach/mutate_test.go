@@ -0,0 +1,121 @@
+package mach
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/forsyth/jsonpath/paths"
+)
+
+type editTest struct {
+	path   string
+	doc    string
+	count  int
+	result string
+}
+
+const editDoc = `{"a": [1, 2, 3, 4, 5], "b": {"c": 1, "d": [{"e": 1}, {"e": 2}]}}`
+
+var setTests = []editTest{
+	{"$", `1`, 1, `"new"`},
+	{"$.b.c", editDoc, 1, `{"a":[1,2,3,4,5],"b":{"c":"new","d":[{"e":1},{"e":2}]}}`},
+	{"$.b.x", editDoc, 0, `{"a":[1,2,3,4,5],"b":{"c":1,"d":[{"e":1},{"e":2}]}}`},
+	{"$.a[1:4:2]", editDoc, 2, `{"a":[1,"new",3,"new",5],"b":{"c":1,"d":[{"e":1},{"e":2}]}}`},
+	{"$.a[0,0,-1]", editDoc, 2, `{"a":["new",2,3,4,"new"],"b":{"c":1,"d":[{"e":1},{"e":2}]}}`},
+	{"$..e", editDoc, 2, `{"a":[1,2,3,4,5],"b":{"c":1,"d":[{"e":"new"},{"e":"new"}]}}`},
+	{"$.b.d[?(@.e > 1)]", editDoc, 1, `{"a":[1,2,3,4,5],"b":{"c":1,"d":[{"e":1},"new"]}}`},
+	{"$..e^", editDoc, 2, `{"a":[1,2,3,4,5],"b":{"c":1,"d":["new","new"]}}`},
+}
+
+var deleteTests = []editTest{
+	{"$", `1`, 1, `null`},
+	{"$.b", editDoc, 1, `{"a":[1,2,3,4,5]}`},
+	{"$.a[1:4]", editDoc, 3, `{"a":[1,5],"b":{"c":1,"d":[{"e":1},{"e":2}]}}`},
+	{"$.a[4,0,2]", editDoc, 3, `{"a":[2,4],"b":{"c":1,"d":[{"e":1},{"e":2}]}}`},
+	{"$.a[?(@ % 2 == 1)]", editDoc, 3, `{"a":[2,4],"b":{"c":1,"d":[{"e":1},{"e":2}]}}`},
+	{"$.b.*", editDoc, 2, `{"a":[1,2,3,4,5],"b":{}}`},
+	{"$..*", editDoc, 13, `{}`},
+	{"$..d[0]", editDoc, 1, `{"a":[1,2,3,4,5],"b":{"c":1,"d":[{"e":2}]}}`},
+}
+
+// TestSet checks Program.Set on various paths.
+func TestSet(t *testing.T) {
+	for i, et := range setTests {
+		prog, doc := editSetup(t, i, et)
+		root, n, err := prog.Set(doc, "new")
+		editCheck(t, i, et, root, n, err)
+	}
+}
+
+// TestDelete checks Program.Delete on various paths.
+func TestDelete(t *testing.T) {
+	for i, et := range deleteTests {
+		prog, doc := editSetup(t, i, et)
+		root, n, err := prog.Delete(doc)
+		editCheck(t, i, et, root, n, err)
+	}
+}
+
+// TestUpdate checks Program.Update, including its handling of errors.
+func TestUpdate(t *testing.T) {
+	et := editTest{"$.a[*]", editDoc, 5, `{"a":[2,4,6,8,10],"b":{"c":1,"d":[{"e":1},{"e":2}]}}`}
+	prog, doc := editSetup(t, 0, et)
+	root, n, err := prog.Update(doc, func(old JSON) (JSON, error) {
+		return cvf(old) * 2, nil
+	})
+	editCheck(t, 0, et, root, n, err)
+	bad := errors.New("bad value")
+	_, n, err = prog.Update(root, func(old JSON) (JSON, error) {
+		if cvf(old) > 6 {
+			return nil, bad
+		}
+		return old, nil
+	})
+	if err != bad || n != 0 {
+		t.Errorf("update error: got (%d, %v), expected (0, %v)", n, err, bad)
+	}
+}
+
+// TestEditNames checks that member names selected by ~ cannot be changed.
+func TestEditNames(t *testing.T) {
+	prog, doc := editSetup(t, 0, editTest{"$.b.*~", editDoc, 0, ""})
+	_, _, err := prog.Set(doc, "new")
+	if err != ErrNames {
+		t.Errorf("set of member names: got %v, expected %v", err, ErrNames)
+	}
+	_, _, err = prog.Delete(doc)
+	if err != ErrNames {
+		t.Errorf("delete of member names: got %v, expected %v", err, ErrNames)
+	}
+}
+
+func editSetup(t *testing.T, i int, et editTest) (*Program, JSON) {
+	path, err := paths.ParsePath(et.path)
+	if err != nil {
+		t.Fatalf("edit test %d: %s: parse: %s", i, et.path, err)
+	}
+	prog, err := Compile(path)
+	if err != nil {
+		t.Fatalf("edit test %d: %s: compile: %s", i, et.path, err)
+	}
+	var doc JSON
+	err = json.Unmarshal([]byte(et.doc), &doc)
+	if err != nil {
+		t.Fatalf("edit test %d: bad document %s: %s", i, et.doc, err)
+	}
+	return prog, doc
+}
+
+func editCheck(t *testing.T, i int, et editTest, root JSON, n int, err error) {
+	if err != nil {
+		t.Errorf("edit test %d: %s: %s", i, et.path, err)
+		return
+	}
+	if got := jsonString(root); got != et.result {
+		t.Errorf("edit test %d: %s: got %s, expected %s", i, et.path, got, et.result)
+	}
+	if n != et.count {
+		t.Errorf("edit test %d: %s: got count %d, expected %d", i, et.path, n, et.count)
+	}
+}
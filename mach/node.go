@@ -0,0 +1,123 @@
+package mach
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Node is a value selected by a path, with its location in the document.
+type Node struct {
+	Value    JSON          // Value is the selected value.
+	Path     string        // Path is the location as an RFC 9535 normalized path, eg $['store']['book'][0].
+	Elements []PathElement // Elements is the location as the sequence of member names and array indices from the root.
+}
+
+// PathElement is one step in the location of a value: an object member name or an array index.
+type PathElement struct {
+	Key   string // Key is the member name, when Index < 0.
+	Index int    // Index is the array index, or -1 for an object member.
+}
+
+// member returns the PathElement for an object member name.
+func member(key string) PathElement {
+	return PathElement{Key: key, Index: -1}
+}
+
+// element returns the PathElement for an array index.
+func element(index int) PathElement {
+	return PathElement{Index: index}
+}
+
+// IsIndex returns true if the PathElement is an array index, and false if it is an object member name.
+func (e PathElement) IsIndex() bool {
+	return e.Index >= 0
+}
+
+// String returns the PathElement in the syntax of a normalized path: ['name'] or [index].
+func (e PathElement) String() string {
+	var sb strings.Builder
+	e.normalize(&sb)
+	return sb.String()
+}
+
+// normalize writes e to sb in normalized path syntax, following RFC 9535 (2.7).
+func (e PathElement) normalize(sb *strings.Builder) {
+	sb.WriteByte('[')
+	if e.IsIndex() {
+		sb.WriteString(strconv.Itoa(e.Index))
+		sb.WriteByte(']')
+		return
+	}
+	sb.WriteByte('\'')
+	for _, r := range e.Key {
+		switch r {
+		case '\'', '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				sb.WriteString(fmt.Sprintf(`\u%04x`, r))
+				break
+			}
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteString("']")
+}
+
+// NormalizedPath returns the RFC 9535 normalized path for a sequence of PathElements from the root.
+func NormalizedPath(elems []PathElement) string {
+	var sb strings.Builder
+	sb.WriteByte('$')
+	for _, e := range elems {
+		e.normalize(&sb)
+	}
+	return sb.String()
+}
+
+// node is a value in the machine's output set.
+// When the machine is tracking locations, it is linked to the node containing it.
+type node struct {
+	val JSON
+	up  *node       // node containing val, or nil at the root (or if locations are not tracked)
+	at  PathElement // location of val within up.val
+}
+
+// child returns the node for val, which is at position at in n's value, linked to n if track is true.
+func (n *node) child(val JSON, at PathElement, track bool) node {
+	if !track {
+		return node{val: val}
+	}
+	return node{val: val, up: n, at: at}
+}
+
+// elements returns the location of n as a sequence of PathElements from the root.
+func (n *node) elements() []PathElement {
+	depth := 0
+	for p := n; p.up != nil; p = p.up {
+		depth++
+	}
+	elems := make([]PathElement, depth)
+	for p := n; p.up != nil; p = p.up {
+		depth--
+		elems[depth] = p.at
+	}
+	return elems
+}
+
+// export returns the Node representing n.
+func (n *node) export() Node {
+	elems := n.elements()
+	return Node{Value: n.val, Path: NormalizedPath(elems), Elements: elems}
+}
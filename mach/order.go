@@ -0,0 +1,169 @@
+package mach
+
+// The order in which the members of objects are visited.
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// KeyOrder determines the order in which wildcards, filters, ".." and functions such as keys visit the members of an object.
+// Go's maps have no order of their own, so without one the results would vary from run to run.
+type KeyOrder interface {
+	// Keys returns the names of the members of obj, in order.
+	Keys(obj map[string]JSON) []string
+}
+
+// SortedKeys visits object members in increasing order of their names (by byte value). It is the default.
+var SortedKeys KeyOrder = sortedKeys{}
+
+// MapOrder visits object members in Go's map iteration order, which is unspecified and varies between runs,
+// but avoids the cost of sorting.
+var MapOrder KeyOrder = mapOrder{}
+
+type sortedKeys struct{}
+
+func (sortedKeys) Keys(obj map[string]JSON) []string {
+	keys := mapOrder{}.Keys(obj)
+	sort.Strings(keys)
+	return keys
+}
+
+type mapOrder struct{}
+
+func (mapOrder) Keys(obj map[string]JSON) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// WithKeyOrder sets the order in which the Program visits the members of objects (SortedKeys by default).
+func WithKeyOrder(order KeyOrder) Option {
+	return func(p *Program) {
+		p.keyOrder = order
+	}
+}
+
+// InKeyOrder returns a copy of the Program that visits the members of objects in the given order.
+// It is cheap, allowing a Program to be applied with each document's own DocumentOrder.
+func (p *Program) InKeyOrder(order KeyOrder) *Program {
+	np := *p
+	np.keyOrder = order
+	return &np
+}
+
+// DocumentOrder is a KeyOrder that visits the members of objects in the order they appeared in the source text,
+// as recorded by DecodeOrdered. Members of objects it did not decode (or members added since) are visited in sorted order.
+type DocumentOrder struct {
+	objects map[uintptr]orderedObject // keyed by the identity of the map
+}
+
+// orderedObject records the source order of the members of obj, which it also keeps alive, so its identity is not reused.
+type orderedObject struct {
+	obj  map[string]JSON
+	keys []string
+}
+
+// DecodeOrdered reads the next JSON value from dec, returning the value as decoded by dec.Decode
+// (so respecting dec's settings, such as UseNumber), and a DocumentOrder that records the order of members in its objects.
+func DecodeOrdered(dec *json.Decoder) (JSON, *DocumentOrder, error) {
+	order := &DocumentOrder{objects: make(map[uintptr]orderedObject)}
+	val, err := order.decode(dec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return val, order, nil
+}
+
+// decode reads the next value from dec, recording the order of members in each object.
+func (d *DocumentOrder) decode(dec *json.Decoder) (JSON, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := make(map[string]JSON)
+		var keys []string
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := tok.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object key %v", tok)
+			}
+			val, err := d.decode(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := obj[key]; !dup {
+				keys = append(keys, key)
+			}
+			obj[key] = val
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		d.objects[reflect.ValueOf(obj).Pointer()] = orderedObject{obj, keys}
+		return obj, nil
+	case json.Delim('['):
+		arr := []JSON{}
+		for dec.More() {
+			val, err := d.decode(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		return arr, nil
+	default:
+		return tok, nil
+	}
+}
+
+// Keys returns the names of obj's members in their source order.
+func (d *DocumentOrder) Keys(obj map[string]JSON) []string {
+	o, ok := d.objects[reflect.ValueOf(obj).Pointer()]
+	if !ok {
+		return SortedKeys.Keys(obj)
+	}
+	keys := make([]string, 0, len(obj))
+	for _, k := range o.keys {
+		if _, ok := obj[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) != len(obj) {
+		// members added since decoding follow, in sorted order
+		var added []string
+		for k := range obj {
+			if !contains(o.keys, k) {
+				added = append(added, k)
+			}
+		}
+		sort.Strings(added)
+		keys = append(keys, added...)
+	}
+	return keys
+}
+
+// contains returns true if keys contains k.
+func contains(keys []string, k string) bool {
+	for _, s := range keys {
+		if s == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package mach
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/forsyth/jsonpath/paths"
+)
+
+const orderDoc = `{"z": 1, "b": {"y": [2, {"c": 3, "a": 4}], "x": 5}, "m": 6}`
+
+type orderTest struct {
+	path   string
+	sorted string // results in SortedKeys order
+	doc    string // results in DocumentOrder
+}
+
+var orderTests = []orderTest{
+	{"$.*", `[{"x":5,"y":[2,{"a":4,"c":3}]},6,1]`, `[1,{"x":5,"y":[2,{"a":4,"c":3}]},6]`},
+	{"$..*", `[{"x":5,"y":[2,{"a":4,"c":3}]},6,1,5,[2,{"a":4,"c":3}],2,{"a":4,"c":3},4,3]`, `[1,{"x":5,"y":[2,{"a":4,"c":3}]},6,[2,{"a":4,"c":3}],5,2,{"a":4,"c":3},3,4]`},
+	{"$[?(@ > 0)]", `[6,1]`, `[1,6]`},
+	{"$..[?(@.a)]", `[{"a":4,"c":3}]`, `[{"a":4,"c":3}]`},
+	{"$.b.y[1][?(keys($.b)[0] == 'x')]", `[4,3]`, `[]`},
+	{"$.b.y[1][?(keys($.b)[0] == 'y')]", `[]`, `[3,4]`},
+	{"$.b.y[1][?(values($.b.y[1])[0] == @)]", `[4]`, `[3]`},
+	{"$.b.y[1][(to_entries($.b.y[1])[0][0])]", `[4]`, `[3]`},
+}
+
+// TestKeyOrder checks that object members are visited in the Program's KeyOrder.
+func TestKeyOrder(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(orderDoc))
+	doc, order, err := DecodeOrdered(dec)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	for i, ot := range orderTests {
+		path, err := paths.ParsePath(ot.path)
+		if err != nil {
+			t.Fatalf("order test %d: %s: parse: %s", i, ot.path, err)
+		}
+		prog, err := Compile(path)
+		if err != nil {
+			t.Fatalf("order test %d: %s: compile: %s", i, ot.path, err)
+		}
+		for j, exp := range []struct {
+			prog   *Program
+			expect string
+		}{{prog, ot.sorted}, {prog.InKeyOrder(order), ot.doc}} {
+			vals, err := exp.prog.Run(doc)
+			if err != nil {
+				t.Errorf("order test %d.%d: %s: run: %s", i, j, ot.path, err)
+				continue
+			}
+			if got := jsonString(vals); got != exp.expect {
+				t.Errorf("order test %d.%d: %s: got %s, expected %s", i, j, ot.path, got, exp.expect)
+			}
+		}
+	}
+}
+
+// TestDocumentOrder checks DocumentOrder's handling of members deleted or added after decoding.
+func TestDocumentOrder(t *testing.T) {
+	doc, order, err := DecodeOrdered(json.NewDecoder(strings.NewReader(`{"c": 1, "a": 2, "b": 3}`)))
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	obj := doc.(map[string]JSON)
+	if got := strings.Join(order.Keys(obj), ","); got != "c,a,b" {
+		t.Errorf("keys: got %s, expected c,a,b", got)
+	}
+	delete(obj, "a")
+	obj["e"] = 4
+	obj["d"] = 5
+	if got := strings.Join(order.Keys(obj), ","); got != "c,b,d,e" {
+		t.Errorf("keys after change: got %s, expected c,b,d,e", got)
+	}
+	if got := strings.Join(order.Keys(map[string]JSON{"y": 1, "x": 2}), ","); got != "x,y" {
+		t.Errorf("keys of other object: got %s, expected x,y", got)
+	}
+}
+
+// cachedOrder is a KeyOrder that returns the same slice each time it is asked about an object.
+type cachedOrder map[string][]string
+
+func (c cachedOrder) Keys(obj map[string]JSON) []string {
+	id := jsonString(obj)
+	if keys, ok := c[id]; ok {
+		return keys
+	}
+	keys := SortedKeys.Keys(obj)
+	c[id] = keys
+	return keys
+}
+
+// TestCachedKeyOrder checks that the machine does not change the names returned by a KeyOrder.
+func TestCachedKeyOrder(t *testing.T) {
+	var doc JSON
+	err := json.Unmarshal([]byte(`{"a": 1, "b": {"x": 2}, "c": [3]}`), &doc)
+	if err != nil {
+		t.Fatalf("bad document: %s", err)
+	}
+	path, err := paths.ParsePath("$..*")
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	order := cachedOrder{}
+	prog, err := Compile(path, WithKeyOrder(order))
+	if err != nil {
+		t.Fatalf("compile: %s", err)
+	}
+	const expect = `[1,{"x":2},[3],2,3]`
+	for i := 0; i < 2; i++ {
+		vals, err := prog.Run(doc)
+		if err != nil {
+			t.Fatalf("run %d: %s", i, err)
+		}
+		if got := jsonString(vals); got != expect {
+			t.Errorf("run %d: got %s, expected %s", i, got, expect)
+		}
+	}
+	if got := strings.Join(order[jsonString(doc)], ","); got != "a,b,c" {
+		t.Errorf("cached keys: got %s, expected a,b,c", got)
+	}
+}
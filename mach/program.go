@@ -3,6 +3,7 @@ package mach
 import (
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/forsyth/jsonpath/paths"
 )
@@ -11,8 +12,9 @@ import (
 // The low order 8 bits hold an Op.
 // smallFlag is the next bit.
 // The next 23 bits is the index field, holding an integer value if smallFlag is set,
-// or a Val table index if smallFlag is zero. OpCall and OpArray use the value as
+// or a Val table index if smallFlag is zero. OpCall, OpArray and OpObject use the value as
 // an operand count, since those are the only operations with zero or more operands.
+// Loops and branches (eg, OpFor, OpRep, OpAnd, OpCond) use it as the target pc.
 type order uint32
 
 const (
@@ -107,8 +109,50 @@ func (r regexpVal) String() string {
 // Program is the compiled form of a Path and associated expressions.
 // It is a program for a simple stack machine, although the details are hidden.
 type Program struct {
-	vals   []paths.Val // unique data values, indexed by an order's index value
-	orders []order     // program text
+	vals      []paths.Val         // unique data values, indexed by an order's index value
+	orders    []order             // program text
+	dialect   paths.Dialect       // semantics of comparison and function calls
+	functions map[string]Function // functions available to OpCall
+	keyOrder  KeyOrder            // order in which object members are visited
+	track     bool                // locations are needed by paths.OpParent, paths.OpKey, @key or @path, even if not reported
+	names     bool                // the path selects member names (paths.OpKey), not values in the document
+	final     int                 // pc of the path's last step, after which the output set holds the results
+
+	regexpCacheSize int          // capacity of regexps, set by WithRegexpCache
+	regexps         *regexpCache // dynamic regular expressions compiled so far, or nil if not cached
+
+	clock func() time.Time // source of the time returned by now(), or nil for time.Now
+}
+
+// Option changes a default setting of a Program as it is compiled.
+type Option func(*Program)
+
+// WithDialect selects the semantics of the given dialect, which should be the one used to parse the path.
+// For RFC9535, comparisons follow the RFC instead of JavaScript's rules, ".length" is an ordinary member name,
+// and the functions available are the RFC's function extensions.
+func WithDialect(dialect paths.Dialect) Option {
+	return func(p *Program) {
+		p.dialect = dialect
+		if dialect == paths.RFC9535 {
+			p.functions = rfcFunctions
+		}
+	}
+}
+
+// WithFunctions adds the given functions to those that the Program can call, replacing predefined functions of the same name.
+// The syntax of the RFC9535 dialect allows only its own function extensions, so functions given for that dialect
+// can only replace those.
+func WithFunctions(fns map[string]Function) Option {
+	return func(p *Program) {
+		all := make(map[string]Function, len(p.functions)+len(fns))
+		for name, fn := range p.functions {
+			all[name] = fn
+		}
+		for name, fn := range fns {
+			all[name] = fn
+		}
+		p.functions = all
+	}
 }
 
 // asm adds an instruction to the program and returns its pc.
@@ -0,0 +1,93 @@
+package mach
+
+// Caching regular expressions that are only known as strings when the Program runs.
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// DefaultRegexpCacheSize is the number of dynamic regular expressions a Program keeps compiled, unless WithRegexpCache says otherwise.
+const DefaultRegexpCacheSize = 64
+
+// regexpCache keeps up to max compiled regular expressions, discarding the least recently used when full.
+// It is shared by all runs of a Program, which can be concurrent.
+type regexpCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element // pattern to its element in lru
+	lru     *list.List               // *regexpEntry, most recently used at the front
+}
+
+// regexpEntry is a compiled regular expression in a regexpCache.
+type regexpEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// newRegexpCache returns a cache for up to max regular expressions, or nil if max is not positive.
+func newRegexpCache(max int) *regexpCache {
+	if max <= 0 {
+		return nil
+	}
+	return &regexpCache{max: max, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// compile returns the compiled form of pattern, compiling it only if it is not in the cache.
+// A nil cache compiles every time.
+// Invalid patterns are not cached, so their errors are reported each time.
+func (c *regexpCache) compile(pattern string) (*regexp.Regexp, error) {
+	if c == nil {
+		return regexp.Compile(pattern)
+	}
+	c.mu.Lock()
+	if el, ok := c.entries[pattern]; ok {
+		c.lru.MoveToFront(el)
+		re := el.Value.(*regexpEntry).re
+		c.mu.Unlock()
+		return re, nil
+	}
+	c.mu.Unlock()
+	// compile without holding the lock; if another run compiles the same pattern meanwhile, either result will do
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[pattern]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*regexpEntry).re, nil
+	}
+	c.entries[pattern] = c.lru.PushFront(&regexpEntry{pattern, re})
+	if c.lru.Len() > c.max {
+		old := c.lru.Remove(c.lru.Back()).(*regexpEntry)
+		delete(c.entries, old.pattern)
+	}
+	return re, nil
+}
+
+// len returns the number of regular expressions in the cache.
+func (c *regexpCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// WithRegexpCache sets the number of dynamic regular expressions (eg, the string operand of =~, or the pattern of tokenize, replace, or RFC 9535 match and search)
+// that the Program keeps compiled, shared by all its runs (DefaultRegexpCacheSize by default).
+// A size of zero or less turns the cache off, so each such expression is compiled every time it is used.
+func WithRegexpCache(size int) Option {
+	return func(p *Program) {
+		p.regexpCacheSize = size
+	}
+}
+
+// regexp returns pattern compiled as a regular expression, from the Program's cache if possible.
+func (p *Program) regexp(pattern string) (*regexp.Regexp, error) {
+	return p.regexps.compile(pattern)
+}
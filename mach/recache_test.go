@@ -0,0 +1,97 @@
+package mach
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/forsyth/jsonpath/paths"
+)
+
+// TestRegexpCache checks that the cache keeps the most recently used expressions, up to its size.
+func TestRegexpCache(t *testing.T) {
+	c := newRegexpCache(2)
+	a, err := c.compile("a+")
+	if err != nil {
+		t.Fatalf("compile: %s", err)
+	}
+	if _, err := c.compile("b+"); err != nil {
+		t.Fatalf("compile: %s", err)
+	}
+	if re, _ := c.compile("a+"); re != a {
+		t.Errorf("a+ was compiled again")
+	}
+	if _, err := c.compile("c+"); err != nil {
+		t.Fatalf("compile: %s", err)
+	}
+	if n := c.len(); n != 2 {
+		t.Errorf("got %d entries, expected 2", n)
+	}
+	if _, ok := c.entries["b+"]; ok {
+		t.Errorf("least recently used b+ was kept")
+	}
+	if re, _ := c.compile("a+"); re != a {
+		t.Errorf("a+ was discarded")
+	}
+	if _, err := c.compile("("); err == nil {
+		t.Errorf("invalid expression compiled")
+	}
+	if n := c.len(); n != 2 {
+		t.Errorf("got %d entries after error, expected 2", n)
+	}
+	if newRegexpCache(0) != nil {
+		t.Errorf("cache of size 0 is not nil")
+	}
+}
+
+// TestDynamicRegexp checks that a Program compiles each dynamic regular expression once, however many runs use it.
+func TestDynamicRegexp(t *testing.T) {
+	tests := []struct {
+		path    string
+		dialect paths.Dialect
+		opts    []Option
+		expect  string
+		cached  int
+	}{
+		{"$.names[?(@ =~ $.pattern)]", paths.Parker, nil, `["ann","anne"]`, 1},
+		{"$.names[?(tokenize(@, $.sep)[0] == 'a')]", paths.Parker, nil, `["a-n-n"]`, 1},
+		{"$.names[?(replace(@, $.sep, '') == 'ann')]", paths.Parker, nil, `["ann","a-n-n"]`, 1},
+		{"$.names[?(@ =~ $.pattern)]", paths.Parker, []Option{WithRegexpCache(0)}, `["ann","anne"]`, 0},
+		{"$.names[?match(@, $.ipattern)]", paths.RFC9535, nil, `["ann","anne"]`, 1},
+		{"$.names[?search(@, $.sep)]", paths.RFC9535, nil, `["a-n-n"]`, 1},
+	}
+	var doc JSON
+	err := json.Unmarshal([]byte(`{"names": ["ann", "bob", "anne", "a-n-n"], "pattern": "^an+e?$", "ipattern": "an+e?", "sep": "-"}`), &doc)
+	if err != nil {
+		t.Fatalf("bad document: %s", err)
+	}
+	for i, dt := range tests {
+		path, err := paths.ParsePathDialect(dt.path, dt.dialect)
+		if err != nil {
+			t.Fatalf("regexp test %d: %s: parse: %s", i, dt.path, err)
+		}
+		prog, err := Compile(path, append([]Option{WithDialect(dt.dialect)}, dt.opts...)...)
+		if err != nil {
+			t.Fatalf("regexp test %d: %s: compile: %s", i, dt.path, err)
+		}
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				vals, err := prog.Run(doc)
+				if err != nil {
+					t.Errorf("regexp test %d: %s: run: %s", i, dt.path, err)
+					return
+				}
+				if got := jsonString(vals); got != dt.expect {
+					t.Errorf("regexp test %d: %s: got %s, expected %s", i, dt.path, got, dt.expect)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := prog.regexps.len(); n != dt.cached {
+			t.Errorf("regexp test %d: %s: %d expressions cached, expected %d", i, dt.path, n, dt.cached)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package mach
 // "When I say 'run!', run!"
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
 	"regexp"
+	"time"
 	"unicode/utf8"
 
 	"github.com/forsyth/jsonpath/paths"
@@ -25,16 +27,41 @@ var (
 // machine is the current state of the virtual machine.
 type machine struct {
 	prog    *Program
-	root    JSON          // $
-	out     []JSON        // current set of output values
-	dot     JSON          // @ in a filter
-	stack   []JSON        // expression stack
-	sp      int           // expression stack pointer
-	pc      int           // next instruction
-	values  []<-chan JSON // values from paths.OpFor for paths.OpFilter or paths.OpNest
+	root    JSON        // $
+	out     []node      // current set of output values
+	dot     node        // @ in a filter
+	stack   []JSON      // expression stack
+	sp      int         // expression stack pointer
+	pc      int         // next instruction
+	loops   []*iterator // values visited by the paths.OpFor and paths.OpNest loops in progress
+	queries []query     // state saved by the paths.OpQuery queries in progress
+	unions  []union     // state of the paths.OpUnionFor unions in progress
+	track   bool        // record the location of each value in out
+	ctx     context.Context
+	done    <-chan struct{} // ctx.Done(), or nil if it can't be cancelled
+	limits  Limits
+	steps   int       // instructions executed
+	time    time.Time // time returned by now() during the run, once read from the Program's clock
+	timeSet bool      // time has been read
 	tracing bool
 }
 
+// union is the state of a union whose selectors apply in turn to each value (paths.OpUnionFor).
+type union struct {
+	vals []node // values to which the selectors apply
+	next int    // index in vals of the value to which they now apply
+	out  []node // results so far
+}
+
+// value returns the output set to which the selectors now apply: the union's current value, or none.
+// It cannot be extended in place, into the values that follow.
+func (u *union) value() []node {
+	if u.next >= len(u.vals) {
+		return []node{}
+	}
+	return u.vals[u.next : u.next+1 : u.next+1]
+}
+
 func (m *machine) push(val JSON) {
 	if m.sp >= len(m.stack) {
 		m.stack = append(m.stack, val)
@@ -45,7 +72,36 @@ func (m *machine) push(val JSON) {
 	}
 }
 
+// query is the state of the machine saved while a query in an expression selects its values.
+type query struct {
+	out []node
+	dot node
+}
+
+// nodeList is the list of values selected by a query in an expression (paths.OpQuery).
+// Most operators want a single value, and pop converts a nodeList to one;
+// existence tests, && and ||, "in", and function calls instead use popNodes to see the whole list.
+type nodeList []JSON
+
+// value returns the only value in the list, or nothing if there are none or several.
+func (l nodeList) value() JSON {
+	if len(l) != 1 {
+		return nothing
+	}
+	return l[0]
+}
+
+// pop removes the value on top of the stack, reducing a nodeList to its single value.
 func (m *machine) pop() JSON {
+	v := m.popNodes()
+	if l, ok := v.(nodeList); ok {
+		return l.value()
+	}
+	return v
+}
+
+// popNodes removes the value on top of the stack, which might be a nodeList.
+func (m *machine) popNodes() JSON {
 	if m.sp == 0 {
 		panic("stack underflow")
 	}
@@ -56,6 +112,17 @@ func (m *machine) pop() JSON {
 }
 
 func (m *machine) popN(n int64) []JSON {
+	a := m.popNodesN(n)
+	for i, v := range a {
+		if l, ok := v.(nodeList); ok {
+			a[i] = l.value()
+		}
+	}
+	return a
+}
+
+// popNodesN removes the top n values from the stack, leaving any nodeLists as they are.
+func (m *machine) popNodesN(n int64) []JSON {
 	if int64(m.sp) < n {
 		panic("stack underflow")
 	}
@@ -77,16 +144,33 @@ func (m *machine) branch(pc int) {
 	m.pc = pc
 }
 
-func (m *machine) pushInput(c <-chan JSON) {
-	m.values = append(m.values, c)
+func (m *machine) pushLoop(it *iterator) {
+	m.loops = append(m.loops, it)
+}
+
+func (m *machine) topLoop() *iterator {
+	return m.loops[len(m.loops)-1]
 }
 
-func (m *machine) topInput() <-chan JSON {
-	return m.values[len(m.values)-1]
+func (m *machine) popLoop() {
+	m.loops[len(m.loops)-1] = nil
+	m.loops = m.loops[0 : len(m.loops)-1]
 }
 
-func (m *machine) popInput() {
-	m.values = m.values[0 : len(m.values)-1]
+// dotp returns a pointer to the current node, to which its children can be linked.
+// If locations are tracked, it must point to a copy, because vm.dot changes as a loop proceeds.
+func (m *machine) dotp() *node {
+	if !m.track {
+		return &m.dot
+	}
+	n := new(node)
+	*n = m.dot
+	return n
+}
+
+// rfc returns true if the machine applies the semantics of RFC 9535.
+func (m *machine) rfc() bool {
+	return m.prog.dialect == paths.RFC9535
 }
 
 // nothing represents an evaluation without a usable result.
@@ -121,11 +205,51 @@ func (m *machine) valsOK(a, b JSON) bool {
 }
 
 // Run applies the current Program to the root of a JSON structure, returning a collection of JSON structures from it (which might be empty) as selected by the original path expression, or a run-time error.
-// Run-time errors include call of an unknown function, an invalid dynamic regular expression (ie, a regular expression as a string variable) and invalid operand types for "~" and "in" ("nin").
+// Run-time errors include an invalid dynamic regular expression (ie, a regular expression as a string variable) and invalid right operand types
+// for "~", "in" ("nin"), "anyof" ("noneof", "subsetof"), "size" and "empty".
 // Following the usual JavaScript conventions, many other errors do not stop evaluation, but yield a null result, detectable using || and &&.
 func (p *Program) Run(root JSON) ([]JSON, error) {
-	vm := &machine{prog: p, root: root, out: []JSON{root}, pc: 0, tracing: false}
+	out, err := p.run(context.Background(), root, false, Limits{})
+	if err != nil {
+		return nil, err
+	}
+	return values(out), nil
+}
+
+// values returns the values in an output set.
+func values(out []node) []JSON {
+	vals := make([]JSON, len(out))
+	for i := range out {
+		vals[i] = out[i].val
+	}
+	return vals
+}
+
+// RunNodes is like Run, but returns each selected value with its location in the document.
+// The location of a member name selected by paths.OpKey ("~") is that of the member.
+func (p *Program) RunNodes(root JSON) ([]Node, error) {
+	out, err := p.run(context.Background(), root, true, Limits{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, len(out))
+	for i := range out {
+		nodes[i] = out[i].export()
+	}
+	return nodes, nil
+}
+
+// run runs the machine, returning the output set, with the location of each value if track is true.
+// It stops with an error if ctx is done or the evaluation exceeds the limits.
+func (p *Program) run(ctx context.Context, root JSON, track bool, limits Limits) ([]node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vm := &machine{prog: p, root: root, out: []node{{val: root}}, pc: 0, track: track || p.track, ctx: ctx, done: ctx.Done(), limits: limits, tracing: false}
 	for vm.pc < len(p.orders) {
+		if err := vm.check(); err != nil {
+			return nil, err
+		}
 		ord := p.orders[vm.pc]
 		vm.pc++
 		switch ord.op() {
@@ -155,7 +279,7 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 		case paths.OpExp:
 			// expression in path is either string or integer (a key or index);
 			// other values are converted to integer.
-			v := vm.pop()
+			v := number(vm.pop())
 			if !vm.valOK(v) {
 				break
 			}
@@ -167,68 +291,132 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 
 		// path operations, working on each member of the current output set
 		case paths.OpWild:
-			vm.out = applySelection(vm.out, func(val JSON, acc []JSON) []JSON {
-				return valsWild(acc, val)
+			err := vm.applySelection(func(src *node, acc []node) []node {
+				return vm.valsWild(acc, src)
 			})
+			if err != nil {
+				return nil, err
+			}
 		case paths.OpMember, paths.OpSelect:
 			negIndex := ord.op() == paths.OpSelect // only [] can index from end of array
 			sel := vm.pop()                        // can be ID, String, Int, Expr(result) or Slice
 			if isNothing(sel) {
-				vm.out = []JSON{}
+				vm.out = []node{}
 				break
 			}
-			vm.out = applySelection(vm.out, func(val JSON, acc []JSON) []JSON {
-				return valsByKey(acc, val, sel, negIndex)
+			err := vm.applySelection(func(src *node, acc []node) []node {
+				return valsByKey(acc, src, sel, negIndex, vm.track)
 			})
+			if err != nil {
+				return nil, err
+			}
 		case paths.OpUnion:
 			// note that it's (apparently) a union that yields a bag, not a set
 			n := ord.smallInt()
 			sels := vm.popN(n)
-			vm.out = applySelection(vm.out, func(val JSON, acc []JSON) []JSON {
+			err := vm.applySelection(func(src *node, acc []node) []node {
 				for _, sel := range sels {
 					if !isNothing(sel) {
-						acc = valsByKey(acc, val, sel, true)
+						acc = valsByKey(acc, src, sel, true, vm.track)
 					}
 				}
 				return acc
 			})
+			if err != nil {
+				return nil, err
+			}
+
+		case paths.OpParent:
+			vm.out = parents(vm.out)
+		case paths.OpKey:
+			err := vm.applySelection(func(src *node, acc []node) []node {
+				if src.up == nil {
+					// $ has no name
+					return acc
+				}
+				var key JSON = src.at.Key
+				if src.at.IsIndex() {
+					key = int64(src.at.Index)
+				}
+				// the name has the location of the member it names
+				return append(acc, node{val: key, up: src.up, at: src.at})
+			})
+			if err != nil {
+				return nil, err
+			}
 
 		// path operations, working on the value in dot
 		case paths.OpFilter, paths.OpNestFilter:
-			v := vm.pop()
+			v := vm.popNodes()
 			//fmt.Printf("FILTER: %#v\n", v)
 			if !isNothing(v) && cvb(v) {
 				vm.out = append(vm.out, vm.dot)
 			}
 		case paths.OpNestWild:
-			vm.out = valsWild(vm.out, vm.dot)
+			vm.out = vm.valsWild(vm.out, vm.dotp())
 		case paths.OpNestMember, paths.OpNestSelect:
 			negIndex := ord.op() == paths.OpNestSelect // only [] can index from end of array
 			sel := vm.pop()                            // can be ID, String, Int, Expr(result) or Slice
 			if !isNothing(sel) {
-				vm.out = valsByKey(vm.out, vm.dot, sel, negIndex)
+				vm.out = valsByKey(vm.out, vm.dotp(), sel, negIndex, vm.track)
 			}
 		case paths.OpNestUnion:
 			// note that it's (apparently) a union that yields a bag, not a set
 			n := ord.smallInt()
 			sels := vm.popN(n)
+			dot := vm.dotp()
 			for _, sel := range sels {
 				if !isNothing(sel) {
-					vm.out = valsByKey(vm.out, vm.dot, sel, true)
+					vm.out = valsByKey(vm.out, dot, sel, true, vm.track)
+				}
+			}
+
+		// applying each selector of a union to each member of vm.out (or to dot, in paths.OpNest) in turn
+		case paths.OpUnionFor:
+			u := union{vals: vm.out, out: []node{}}
+			if ord.smallInt() != 0 {
+				u.vals, u.out = []node{vm.dot}, vm.out
+			}
+			vm.unions = append(vm.unions, u)
+			vm.out = u.value()
+		case paths.OpUnionNext, paths.OpUnionRep:
+			u := &vm.unions[len(vm.unions)-1]
+			u.out = append(u.out, vm.out...)
+			if err := vm.checkOutput(len(u.out)); err != nil {
+				return nil, err
+			}
+			if ord.op() == paths.OpUnionRep {
+				u.next++
+				if u.next >= len(u.vals) {
+					vm.out = u.out
+					vm.unions[len(vm.unions)-1] = union{}
+					vm.unions = vm.unions[:len(vm.unions)-1]
+					break
 				}
+				vm.branch(ord.pc())
 			}
+			vm.out = u.value()
 
 		// iterating over members of current vm.out directly (paths.OpFor) and all their descendents (paths.OpNest)
 		case paths.OpFor:
-			looptop(vm, stepping, ord.pc())
+			err := looptop(vm, false, ord.pc())
+			if err != nil {
+				return nil, err
+			}
 		case paths.OpNest:
-			looptop(vm, walker, ord.pc())
+			err := looptop(vm, true, ord.pc())
+			if err != nil {
+				return nil, err
+			}
 		case paths.OpRep:
-			js, more := <-vm.topInput()
+			js, more, err := vm.topLoop().step()
+			if err != nil {
+				return nil, err
+			}
 			if !more {
 				//fmt.Printf("rep: all done\n")
-				vm.popInput()
-				vm.dot = nil
+				vm.popLoop()
+				vm.dot = node{}
 				break
 			}
 			vm.dot = js
@@ -239,7 +427,33 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 		case paths.OpRoot:
 			vm.push(vm.root)
 		case paths.OpCurrent:
-			vm.push(vm.dot)
+			vm.push(vm.dot.val)
+		case paths.OpCurrentKey:
+			if vm.dot.up == nil {
+				// $ (or no @ at all) has no name
+				vm.push(nothing)
+				break
+			}
+			if vm.dot.at.IsIndex() {
+				vm.push(int64(vm.dot.at.Index))
+				break
+			}
+			vm.push(vm.dot.at.Key)
+		case paths.OpCurrentPath:
+			vm.push(NormalizedPath(vm.dot.elements()))
+		case paths.OpQuery:
+			// the query's steps select from @ or $ (ord.smallInt() != 0) as from the root of a path
+			vm.queries = append(vm.queries, query{vm.out, vm.dot})
+			start := node{val: vm.root}
+			if ord.smallInt() == 0 {
+				start = vm.dot
+			}
+			vm.out = []node{start}
+		case paths.OpQueryEnd:
+			vm.push(nodeList(values(vm.out)))
+			q := vm.queries[len(vm.queries)-1]
+			vm.queries = vm.queries[:len(vm.queries)-1]
+			vm.out, vm.dot = q.out, q.dot
 		case paths.OpDot:
 			sel := vm.pop()
 			val := vm.pop()
@@ -250,7 +464,7 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 			if !vm.valOK(key) {
 				break
 			}
-			if key.S() == "length" {
+			if key.S() == "length" && !vm.rfc() {
 				n := -1
 				switch val := val.(type) {
 				case []JSON:
@@ -331,25 +545,36 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 			//fmt.Printf("slice=%v %#v\n", slice, array)
 			vm.push(slicing(array, slice))
 		case paths.OpOr:
-			b := vm.pop()
-			a := vm.pop()
-			if isNothing(a) && isNothing(b) {
-				vm.push(nothing)
-				break
-			}
+			// a true left operand is the result, skipping the right one that follows, which is otherwise the result
+			a := vm.popNodes()
 			if !isNothing(a) && cvb(a) {
 				vm.push(a)
-				break
+				vm.branch(ord.pc())
 			}
-			vm.push(b)
 		case paths.OpAnd:
-			b := vm.pop()
-			a := vm.pop()
+			// a false left operand is the result, skipping the right one that follows, which is otherwise the result
+			a := vm.popNodes()
 			if isNothing(a) || !cvb(a) {
 				vm.push(a)
+				vm.branch(ord.pc())
+			}
+		case paths.OpCond:
+			// evaluate the first alternative, which follows, or branch to the second
+			if !cvb(vm.popNodes()) {
+				vm.branch(ord.pc())
+			}
+		case paths.OpCoalesce:
+			// keep a value that is neither null nor nothing, and skip the alternative that follows
+			v := vm.popNodes()
+			if l, ok := v.(nodeList); ok && len(l) == 0 {
 				break
 			}
-			vm.push(b)
+			if v != nil && !isNothing(v) {
+				vm.push(v)
+				vm.branch(ord.pc())
+			}
+		case paths.OpJump:
+			vm.branch(ord.pc())
 		case paths.OpAdd:
 			// TO DO: allow string+string concatenation?
 			b := vm.pop()
@@ -398,38 +623,81 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 				vm.push(-cvi(v))
 			}
 		case paths.OpNot:
-			vm.push(!cvb(vm.pop()))
+			vm.push(!cvb(vm.popNodes()))
+		case paths.OpExists:
+			switch v := vm.popNodes().(type) {
+			case nodeList:
+				vm.push(len(v) != 0)
+			default:
+				vm.push(!isNothing(v))
+			}
 		case paths.OpEQ:
-			b := vm.pop()
-			a := vm.pop()
+			b := vm.popNodes()
+			a := vm.popNodes()
+			if vm.rfc() {
+				vm.push(rfcCompare(ord.op(), a, b))
+				break
+			}
 			vm.push(eqVal(a, b))
 		case paths.OpNE:
-			b := vm.pop()
-			a := vm.pop()
+			b := vm.popNodes()
+			a := vm.popNodes()
+			if vm.rfc() {
+				vm.push(rfcCompare(ord.op(), a, b))
+				break
+			}
 			vm.push(!eqVal(a, b))
 		case paths.OpLT:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.rfc() {
+				vm.push(rfcCompare(ord.op(), a, b))
+				break
+			}
 			vm.push(relation(a, b, func(i, j int64) bool { return i < j },
 				func(x, y float64) bool { return x < y }, func(s, t string) bool { return s < t }))
 		case paths.OpLE:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.rfc() {
+				vm.push(rfcCompare(ord.op(), a, b))
+				break
+			}
 			vm.push(relation(a, b, func(i, j int64) bool { return i <= j },
 				func(x, y float64) bool { return x <= y }, func(s, t string) bool { return s <= t }))
 		case paths.OpGE:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.rfc() {
+				vm.push(rfcCompare(ord.op(), a, b))
+				break
+			}
 			vm.push(relation(a, b, func(i, j int64) bool { return i >= j },
 				func(x, y float64) bool { return x >= y }, func(s, t string) bool { return s >= t }))
 		case paths.OpGT:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.rfc() {
+				vm.push(rfcCompare(ord.op(), a, b))
+				break
+			}
 			vm.push(relation(a, b, func(i, j int64) bool { return i > j },
 				func(x, y float64) bool { return x > y }, func(s, t string) bool { return s > t }))
 		case paths.OpArray:
 			n := ord.smallInt()
 			vm.push(vm.popN(n))
+		case paths.OpObject:
+			// names and values alternate
+			n := ord.smallInt()
+			kids := vm.popN(n)
+			obj := make(map[string]JSON, n/2)
+			for i := 0; i < len(kids); i += 2 {
+				if !isNothing(kids[i+1]) {
+					// as in JavaScript, an undefined value leaves the member out
+					obj[kids[i].(string)] = kids[i+1]
+				}
+			}
+			vm.push(obj)
 		case paths.OpMatch:
 			b := vm.pop()
 			a := vm.pop()
@@ -443,8 +711,8 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 				// already compiled
 				re = b
 			case string:
-				// dynamic string value, to be compiled now
-				re, err = regexp.Compile(b)
+				// dynamic string value, compiled now unless it has been seen before
+				re, err = p.regexp(b)
 				if err != nil {
 					return nil, err // user visible so don't include pc
 				}
@@ -458,8 +726,12 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 				return nil, fmt.Errorf("%s requires string left operand, not %s", ord.op(), a)
 			}
 		case paths.OpIn, paths.OpNin:
-			b := vm.pop()
+			b := vm.popNodes()
 			a := vm.pop()
+			if l, ok := b.(nodeList); ok {
+				// a value in the list selected by a query
+				b = []JSON(l)
+			}
 			if !vm.valsOK(a, b) {
 				break
 			}
@@ -469,11 +741,59 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 			default:
 				return nil, fmt.Errorf("%s requires array right operand, not %s", ord.op(), b)
 			}
+		case paths.OpAnyof, paths.OpNoneof, paths.OpSubsetof:
+			b := vm.popNodes()
+			a := vm.popNodes()
+			if !vm.valsOK(a, b) {
+				break
+			}
+			set, ok := arrayOperand(b)
+			if !ok {
+				return nil, fmt.Errorf("%s requires array right operand, not %#v", ord.op(), b)
+			}
+			vals, ok := arrayOperand(a)
+			if !ok {
+				vm.push(false)
+				break
+			}
+			vm.push(setRelation(ord.op(), vals, set))
+		case paths.OpSize:
+			b := singleOperand(vm.popNodes())
+			a := vm.popNodes()
+			if !vm.valsOK(a, b) {
+				break
+			}
+			size, ok := intArg(b)
+			if !ok {
+				return nil, fmt.Errorf("%s requires integer right operand, not %#v", ord.op(), b)
+			}
+			n, ok := operandSize(a)
+			vm.push(ok && n == int64(size))
+		case paths.OpEmpty:
+			b := singleOperand(vm.popNodes())
+			a := vm.popNodes()
+			if !vm.valsOK(a, b) {
+				break
+			}
+			empty, ok := b.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s requires boolean right operand, not %#v", ord.op(), b)
+			}
+			n, ok := operandSize(a)
+			vm.push(ok && (n == 0) == empty)
 		case paths.OpCall:
 			n := ord.smallInt()
-			args := vm.popN(n)
+			args := vm.popNodesN(n)
+			if !vm.rfc() {
+				// RFC 9535 functions distinguish a query's nodes from values, but the others take the values as an array
+				for i, v := range args {
+					if l, ok := v.(nodeList); ok {
+						args[i] = []JSON(l)
+					}
+				}
+			}
 			id := args[0].(paths.NameVal)
-			result, err := call(id.S(), args[1:])
+			result, err := vm.call(id.S(), args[1:])
 			if err != nil {
 				return nil, err
 			}
@@ -489,7 +809,7 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 				if i != 0 {
 					fmt.Print(", ")
 				}
-				fmt.Print(jsonString(x))
+				fmt.Print(jsonString(x.val))
 			}
 			fmt.Printf("]\n")
 			// show the stack
@@ -503,46 +823,82 @@ func (p *Program) Run(root JSON) ([]JSON, error) {
 			fmt.Print("]\n")
 		}
 	}
+	if vm.limits.MaxOutput > 0 && len(vm.out) > vm.limits.MaxOutput {
+		return nil, &LimitError{ErrOutputLimit, vm.limits.MaxOutput}
+	}
+	if vm.limits.MaxResults > 0 && len(vm.out) > vm.limits.MaxResults {
+		return nil, &LimitError{ErrResultLimit, vm.limits.MaxResults}
+	}
 	if vm.out == nil {
-		return []JSON{}, nil
+		return []node{}, nil
 	}
 	return vm.out, nil
 }
 
-// call invokes function named id with the given arguments, returning a result or an error.
-func call(id string, args []JSON) (JSON, error) {
-	fn := functions[id]
-	if fn.fn == nil {
-		return nil, fmt.Errorf("call of unknown function: %s", id)
+// call invokes the function named id with the given arguments, returning a result or an error.
+func (m *machine) call(id string, args []JSON) (JSON, error) {
+	// Compile has checked the calls in a Program, but tests call functions directly
+	fn, ok := m.prog.function(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFunc, id)
+	}
+	if !fn.accepts(len(args)) {
+		return nil, fmt.Errorf("%s: %w: need %s, got %d", id, ErrArgCount, fn.arity(), len(args))
 	}
-	if fn.na != AnyNumber && len(args) != fn.na {
-		return nil, fmt.Errorf("%s: wrong argument count: need %d, got %d", id, fn.na, len(args))
+	if fn.pfn != nil {
+		return fn.pfn(m, args), nil
 	}
 	return fn.fn(args), nil
 }
 
-// apply runs the selection function f on each element of the src array, returning a new array with the results.
-func applySelection(src []JSON, f func(JSON, []JSON) []JSON) []JSON {
-	vals := []JSON{}
-	for _, el := range src {
-		vals = f(el, vals)
+// applySelection replaces the output set by the values that the selection function f selects from each of its members,
+// or returns a *LimitError if they exceed the machine's limits.
+func (vm *machine) applySelection(f func(*node, []node) []node) error {
+	vals := []node{}
+	for i := range vm.out {
+		vals = f(&vm.out[i], vals)
+		// stop as soon as the limits are exceeded, not at the next instruction
+		if err := vm.checkOutput(len(vals)); err != nil {
+			return err
+		}
+	}
+	vm.out = vals
+	return nil
+}
+
+// parents returns the values containing those in src, once each, in order of first appearance.
+// The root has no parent.
+func parents(src []node) []node {
+	vals := []node{}
+	seen := make(map[*node]bool)
+	for i := range src {
+		up := src[i].up
+		if up == nil || seen[up] {
+			continue
+		}
+		seen[up] = true
+		vals = append(vals, *up)
 	}
 	return vals
 }
 
-// looptop sets up iteration (paths.OpFor, paths.OpNest) over a set of values produced by the producer process.
-func looptop(vm *machine, producer func(chan<- JSON, []JSON), epc int) {
-	if len(vm.out) == 0 {
-		//fmt.Printf("loop: empty out\n")
+// looptop sets up iteration (paths.OpFor, or paths.OpNest if nest is true) over the values visited from the current output set,
+// making the first one dot, or branching to epc if there are none.
+func looptop(vm *machine, nest bool, epc int) error {
+	it := newIterator(vm, vm.out, nest)
+	vm.out = []node{}
+	first, ok, err := it.step()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		//fmt.Printf("loop: nothing to visit\n")
 		vm.branch(epc)
-		return
+		return nil
 	}
-	// TO DO: special case len(vm.out) == 1, just set vm.dot
-	values := make(chan JSON)
-	vm.pushInput(values)
-	go producer(values, vm.out)
-	vm.out = []JSON{}
-	vm.dot = <-values
+	vm.pushLoop(it)
+	vm.dot = first
+	return nil
 }
 
 // sliceEval returns an interpretation of the given Slice with respect to an array of length l.
@@ -664,6 +1020,10 @@ func relation(a, b JSON, intf func(int64, int64) bool, floatf func(float64, floa
 		}
 		return stringf(cvs(a), cvs(b))
 	}
+	if x, y, ok := bigNums(a, b); ok {
+		// compare exactly, as integers -1, 0 or 1 against 0
+		return intf(int64(x.Cmp(y)), 0)
+	}
 	if isFloat(a) || isFloat(b) {
 		//fmt.Printf("REL2 %#v %#v XX %v %v %v\n", cvf(a), cvf(b), isFloat(a), isFloat(b), isInt(b))
 		return floatf(cvf(a), cvf(b))
@@ -672,6 +1032,64 @@ func relation(a, b JSON, intf func(int64, int64) bool, floatf func(float64, floa
 	return intf(cvi(a), cvi(b))
 }
 
+// arrayOperand returns v as an array, and true, if it is an array or the list of values selected by a query.
+func arrayOperand(v JSON) ([]JSON, bool) {
+	switch v := v.(type) {
+	case []JSON:
+		return v, true
+	case nodeList:
+		return []JSON(v), true
+	default:
+		return nil, false
+	}
+}
+
+// setRelation returns the result of op (OpAnyof, OpNoneof or OpSubsetof), comparing the values of a and b as sets.
+func setRelation(op paths.Op, a, b []JSON) bool {
+	switch op {
+	case paths.OpAnyof, paths.OpNoneof:
+		for _, v := range a {
+			if searchJSON(b, v, true) {
+				return op == paths.OpAnyof
+			}
+		}
+		return op == paths.OpNoneof
+	default:
+		for _, v := range a {
+			if !searchJSON(b, v, true) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// singleOperand returns the only value of a list selected by a query, or nothing if the list is empty.
+// Unlike pop, it converts a list of several values to an array, to be rejected by the operator, not treated as nothing.
+func singleOperand(v JSON) JSON {
+	if l, ok := v.(nodeList); ok {
+		if len(l) > 1 {
+			return []JSON(l)
+		}
+		return l.value()
+	}
+	return v
+}
+
+// operandSize returns the length of an array, string or list of values selected by a query, and true, or false for any other value.
+func operandSize(v JSON) (int64, bool) {
+	switch v := v.(type) {
+	case string:
+		return int64(utf8.RuneCountInString(v)), true
+	case []JSON:
+		return int64(len(v)), true
+	case nodeList:
+		return int64(len(v)), true
+	default:
+		return 0, false
+	}
+}
+
 // slicing returns the slice of src.
 func slicing(src []JSON, slice *paths.Slice) []JSON {
 	start, end, stride := sliceEval(slice, int64(len(src)))
@@ -696,16 +1114,16 @@ func slicing(src []JSON, slice *paths.Slice) []JSON {
 }
 
 // valsWild adds to vals the members of objects and elements of arrays in src.
-func valsWild(vals []JSON, src JSON) []JSON {
-	switch src := src.(type) {
+func (vm *machine) valsWild(vals []node, src *node) []node {
+	switch val := src.val.(type) {
 	case []JSON:
-		for _, el := range src {
+		for i, el := range val {
 			//fmt.Printf("el: %#v\n", el)
-			vals = append(vals, el)
+			vals = append(vals, src.child(el, element(i), vm.track))
 		}
 	case map[string]JSON:
-		for _, v := range src {
-			vals = append(vals, v)
+		for _, k := range vm.prog.keyOrder.Keys(val) {
+			vals = append(vals, src.child(val[k], member(k), vm.track))
 		}
 	}
 	return vals
@@ -713,42 +1131,43 @@ func valsWild(vals []JSON, src JSON) []JSON {
 
 // valsByKey adds to vals a set of values from the src that satisfy the given key (eg, member name, index, slice).
 // TO DO: use a map to check whether the values have been seen when forming a union.
-func valsByKey(vals []JSON, src JSON, key JSON, negIndex bool) []JSON {
+func valsByKey(vals []node, src *node, key JSON, negIndex bool, track bool) []node {
 	if isSlice(key) {
-		src, ok := src.([]JSON)
+		a, ok := src.val.([]JSON)
 		if !ok {
-			return nil
+			return vals
 		}
 		slice := key.(*paths.Slice)
-		start, end, stride := sliceEval(slice, int64(len(src)))
+		start, end, stride := sliceEval(slice, int64(len(a)))
 		switch {
 		case stride > 0:
 			for i := start; i < end; i += stride {
-				vals = append(vals, src[i])
+				vals = append(vals, src.child(a[i], element(int(i)), track))
 			}
 		case stride < 0:
 			for i := start; i > end; i += stride {
-				vals = append(vals, src[i])
+				vals = append(vals, src.child(a[i], element(int(i)), track))
 			}
 		case stride == 0:
 			// could yield an error, but in the spirit of jsonPath, we'll do nothing
 		}
 		return vals
 	}
-	switch src := src.(type) {
+	switch val := src.val.(type) {
 	case []JSON:
 		if isInt(key) {
 			// [integer]
-			v, ok := valByKey(src, key, negIndex)
+			i, ok := arrayIndex(val, key, negIndex)
 			if ok {
-				vals = append(vals, v)
+				vals = append(vals, src.child(val[i], element(i), track))
 			}
 		}
 		return vals
 	case map[string]JSON:
-		v, ok := valByKey(src, key, false)
+		k := mapKey(key)
+		v, ok := val[k]
 		if ok {
-			vals = append(vals, v)
+			vals = append(vals, src.child(v, member(k), track))
 		}
 	default:
 		// neither object nor array
@@ -785,17 +1204,13 @@ func valByKey(src JSON, key JSON, negIndex bool) (JSON, bool) {
 		//fmt.Printf(" -> %#v\n", v)
 		return v, true
 	case []JSON:
-		l := int64(len(src))
-		n := cvi(key)
-		if negIndex && n < 0 {
-			n += l
-		}
-		if n >= 0 && n < l {
-			//fmt.Printf(" -> %#v\n", src[n])
-			return src[n], true
+		n, ok := arrayIndex(src, key, negIndex)
+		if !ok {
+			//fmt.Printf(" -> nil\n")
+			return nil, false
 		}
-		//fmt.Printf(" -> nil\n")
-		return nil, false
+		//fmt.Printf(" -> %#v\n", src[n])
+		return src[n], true
 	case nil:
 		// null.key is null
 		return src, true
@@ -805,53 +1220,16 @@ func valByKey(src JSON, key JSON, negIndex bool) (JSON, bool) {
 	}
 }
 
-// stepping sends the JSON structures from the given array one at a time on values.
-func stepping(values chan<- JSON, vals []JSON) {
-	defer close(values)
-	for _, v := range vals {
-		switch v := v.(type) {
-		case []JSON:
-			for _, el := range v {
-				values <- el
-			}
-		case map[string]JSON:
-			for _, el := range v {
-				values <- el
-			}
-		}
-	}
-}
-
-// walker walks down a sequence of JSON structures passing object and array substructure back in values.
-// The order is defined in 9.1.1.8 [[Descendants]] of
-// https://www.ecma-international.org/wp-content/uploads/ECMA-357_2nd_edition_december_2005.pdf
-func walker(values chan<- JSON, vals []JSON) {
-	defer close(values)
-	for _, v := range vals {
-		if IsStructure(v) {
-			walkdown(values, v)
-		}
+// arrayIndex converts key to an index of array a, returning the index and true if it is within bounds.
+// If negIndex is true, a negative key indexes from the end of the array.
+func arrayIndex(a []JSON, key JSON, negIndex bool) (int, bool) {
+	l := int64(len(a))
+	n := cvi(key)
+	if negIndex && n < 0 {
+		n += l
 	}
-}
-
-func walkdown(values chan<- JSON, val JSON) {
-	values <- val
-	switch val := val.(type) {
-	case map[string]JSON:
-		// note object members, and walk down from each member that's an array or object
-		for _, v := range val {
-			if IsStructure(v) {
-				walkdown(values, v)
-			}
-		}
-	case []JSON:
-		// elements
-		for _, v := range val {
-			if IsStructure(v) {
-				walkdown(values, v)
-			}
-		}
-	default:
-		// bool, float64, string or nil
+	if n < 0 || n >= l {
+		return 0, false
 	}
+	return int(n), true
 }
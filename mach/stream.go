@@ -0,0 +1,397 @@
+package mach
+
+// Evaluating a path over a stream of JSON tokens, without decoding the whole document.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/forsyth/jsonpath/paths"
+)
+
+var (
+	ErrNotStreamable = errors.New("path cannot be evaluated on a stream")
+)
+
+// Stream evaluates a path over a stream of JSON tokens from an encoding/json Decoder,
+// decoding only the values that the path might select or that a filter must test.
+// Only some paths can be streamed: those with member, index, slice, union and wildcard selections
+// (but no indices from the end of an array), "..", and filters that refer only to the current value (@).
+//
+// The Stream works like an automaton whose states are positions in the path: the states reached by a value
+// determine those reached by each of its members or elements. When a value reaches the end of the path,
+// or must be tested by a filter, it is decoded and the rest of the path is applied to it by an ordinary Program.
+// Each state counts the ways the value reached it (eg, through a union that names it twice, or nested ".."),
+// so a value is selected as many times as by a Program.
+type Stream struct {
+	steps   paths.Path
+	filters []*Program // filters[i] tests a candidate for steps[i], when that is a filter
+	rest    []*Program // rest[i] evaluates steps[i:] on a decoded value
+}
+
+// state is a position in the path reached by a value, and the number of ways it was reached.
+type state struct {
+	i int // index of the next step
+	n int // number of ways
+}
+
+// CompileStream returns a Stream that evaluates the given path, or ErrNotStreamable (wrapped in an explanation)
+// if the path is outside the subset that can be streamed.
+// Options, if any, apply to the Programs that the Stream uses for decoded values, which share one cache of regular expressions.
+func CompileStream(path paths.Path, opts ...Option) (*Stream, error) {
+	s := &Stream{steps: path, filters: make([]*Program, len(path)), rest: make([]*Program, len(path))}
+	var regexps *regexpCache
+	compile := func(path paths.Path) (*Program, error) {
+		prog, err := Compile(path, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if regexps == nil {
+			regexps = prog.regexps
+		} else {
+			prog.regexps = regexps
+		}
+		return prog, nil
+	}
+	for i, step := range path {
+		err := streamable(step)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotStreamable, err)
+		}
+		if step.Op == paths.OpFilter {
+			s.filters[i], err = compile(paths.Path{step})
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.rest[i], err = compile(path[i:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// streamable returns an error explaining why step cannot be streamed, or nil if it can.
+func streamable(step *paths.Step) error {
+	switch step.Op {
+	case paths.OpWild, paths.OpNestWild:
+		return nil
+	case paths.OpMember, paths.OpNestMember:
+		return streamKey(step.Args[0], false)
+	case paths.OpSelect, paths.OpNestSelect, paths.OpUnion, paths.OpNestUnion:
+		if isSelectors(step.Args) {
+			return errors.New("union with a wildcard, filter or expression among its selectors")
+		}
+		for _, arg := range step.Args {
+			err := streamKey(arg, true)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case paths.OpFilter:
+		e := step.Args[0].(paths.Expr)
+		if uses(e, paths.OpRoot) {
+			return errors.New("filter refers to $, which needs the whole document")
+		}
+		if uses(e, paths.OpCurrentKey) || uses(e, paths.OpCurrentPath) {
+			return errors.New("filter refers to @key or @path, the location of the value")
+		}
+		return nil
+	case paths.OpNestFilter:
+		return errors.New("..[?()] tests every structure in the document, including the document itself")
+	default:
+		return fmt.Errorf("unsupported step %s", step.Op)
+	}
+}
+
+// streamKey returns an error if a member name, index or slice cannot be matched without knowing the length of an array.
+// Indices can be negative only if they are never applied to arrays (ie, in dot notation).
+func streamKey(key paths.Val, negIndex bool) error {
+	switch key := key.(type) {
+	case paths.NameVal, paths.StringVal:
+		return nil
+	case paths.IntVal:
+		if negIndex && key.V() < 0 {
+			return fmt.Errorf("index %d counts from the end of the array", key.V())
+		}
+		return nil
+	case *paths.Slice:
+		for _, v := range []paths.Val{key.Start, key.End, key.Stride} {
+			if v == nil {
+				continue
+			}
+			n, ok := v.(paths.IntVal)
+			if !ok {
+				return fmt.Errorf("slice %s has a bound that is not an integer constant", key)
+			}
+			if n.V() < 0 || n.V() == 0 && v == key.Stride {
+				return fmt.Errorf("slice %s needs the length of the array", key)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("selection by expression %s", key)
+	}
+}
+
+// uses returns true if expression e contains op (eg, refers to the document root with paths.OpRoot).
+func uses(e paths.Expr, op paths.Op) bool {
+	if e.Opcode() == op {
+		return true
+	}
+	switch t := e.(type) {
+	case *paths.Inner:
+		for _, k := range t.Kids {
+			if uses(k, op) {
+				return true
+			}
+		}
+	case *paths.QueryLeaf:
+		if t.Root == op {
+			return true
+		}
+		for _, step := range t.Path {
+			for _, arg := range step.Args {
+				if valUses(arg, op) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// valUses returns true if v is an expression, or a slice with an expression as a bound, that contains op.
+func valUses(v paths.Val, op paths.Op) bool {
+	switch v := v.(type) {
+	case paths.Expr:
+		return uses(v, op)
+	case *paths.Slice:
+		return valUses(v.Start, op) || valUses(v.End, op) || valUses(v.Stride, op)
+	}
+	return false
+}
+
+// Eval applies the path to each JSON value read from r in turn, calling emit with each value selected.
+// Numbers are decoded as float64, as by json.Unmarshal. See EvalDecoder.
+func (s *Stream) Eval(r io.Reader, emit func(JSON) error) error {
+	return s.EvalDecoder(json.NewDecoder(r), emit)
+}
+
+// EvalDecoder applies the path to each JSON value read from dec in turn, calling emit with each value selected.
+// Values are decoded as by DecodeOrdered, respecting dec's settings (eg, UseNumber), and the members of objects
+// are visited in the order they appear, so the values emitted are those that Run would select from the same value
+// decoded by DecodeOrdered, with the Program InKeyOrder its DocumentOrder, including any value selected more than once.
+// They are emitted in document order of their locations, however, which can differ from the order of Run's results.
+// If emit returns an error, evaluation stops and EvalDecoder returns that error.
+func (s *Stream) EvalDecoder(dec *json.Decoder, emit func(JSON) error) error {
+	for dec.More() {
+		err := s.value(dec, []state{{0, 1}}, nil, emit)
+		if err != nil {
+			return err
+		}
+	}
+	// distinguish the end of input from a syntax error
+	_, err := dec.Token()
+	if err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected delimiter in JSON input")
+		}
+		return err
+	}
+	return nil
+}
+
+// value processes the next value from dec, which has reached the given states.
+// tests lists the filter steps whose filter the value must pass to reach the following state.
+func (s *Stream) value(dec *json.Decoder, states []state, tests []state, emit func(JSON) error) error {
+	if len(states) == 0 && len(tests) == 0 {
+		return skip(dec)
+	}
+	if len(tests) > 0 || states[len(states)-1].i == len(s.steps) {
+		v, order, err := DecodeOrdered(dec)
+		if err != nil {
+			return err
+		}
+		for _, t := range tests {
+			out, err := s.filters[t.i].InKeyOrder(order).Run([]JSON{v})
+			if err != nil {
+				return err
+			}
+			if len(out) > 0 {
+				states = addState(states, t.i+1, t.n)
+			}
+		}
+		return s.results(v, order, states, emit)
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := tok.(string)
+			if !ok {
+				return fmt.Errorf("unexpected object key %v", tok)
+			}
+			next, tests := s.next(states, member(key))
+			err = s.value(dec, next, tests, emit)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			next, tests := s.next(states, element(i))
+			err = s.value(dec, next, tests, emit)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	default:
+		// a scalar value has no members to select
+	}
+	return err
+}
+
+// results emits the values selected from v, which has been decoded (with the given order) after reaching the given states.
+func (s *Stream) results(v JSON, order *DocumentOrder, states []state, emit func(JSON) error) error {
+	for _, st := range states {
+		out := []JSON{v}
+		if st.i < len(s.steps) {
+			var err error
+			out, err = s.rest[st.i].InKeyOrder(order).Run(v)
+			if err != nil {
+				return err
+			}
+		}
+		for _, o := range out {
+			for k := 0; k < st.n; k++ {
+				err := emit(o)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// next returns the states reached by a member or element at position at in a value that has reached states,
+// and the filter steps it must be tested against.
+func (s *Stream) next(states []state, at PathElement) ([]state, []state) {
+	var next, tests []state
+	for _, st := range states {
+		step := s.steps[st.i]
+		match := 0
+		switch step.Op {
+		case paths.OpWild:
+			match = 1
+		case paths.OpNestWild:
+			next = addState(next, st.i, st.n)
+			match = 1
+		case paths.OpMember, paths.OpSelect, paths.OpUnion:
+			match = matchKeys(step.Args, at)
+		case paths.OpNestMember, paths.OpNestSelect, paths.OpNestUnion:
+			next = addState(next, st.i, st.n)
+			match = matchKeys(step.Args, at)
+		case paths.OpFilter:
+			tests = append(tests, st)
+		}
+		if match > 0 {
+			next = addState(next, st.i+1, st.n*match)
+		}
+	}
+	return next, tests
+}
+
+// matchKeys returns the number of the keys (checked by streamKey) that select the value at position at,
+// which can be more than one in a union.
+func matchKeys(keys []paths.Val, at PathElement) int {
+	n := 0
+	for _, key := range keys {
+		switch key := key.(type) {
+		case paths.NameVal:
+			if !at.IsIndex() && at.Key == key.S() {
+				n++
+			}
+		case paths.StringVal:
+			if !at.IsIndex() && at.Key == key.S() {
+				n++
+			}
+		case paths.IntVal:
+			if at.IsIndex() && int64(at.Index) == key.V() || !at.IsIndex() && at.Key == strconv.FormatInt(key.V(), 10) {
+				n++
+			}
+		case *paths.Slice:
+			if at.IsIndex() && inSlice(key, int64(at.Index)) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// inSlice returns true if a slice with non-negative constant bounds selects index i.
+func inSlice(slice *paths.Slice, i int64) bool {
+	start, stride := int64(0), int64(1)
+	if slice.Start != nil {
+		start = slice.Start.(paths.IntVal).V()
+	}
+	if slice.End != nil && i >= slice.End.(paths.IntVal).V() {
+		return false
+	}
+	if slice.Stride != nil {
+		stride = slice.Stride.(paths.IntVal).V()
+	}
+	return i >= start && (i-start)%stride == 0
+}
+
+// addState adds n ways of reaching state i to states, which is ordered by i.
+func addState(states []state, i int, n int) []state {
+	for j, s := range states {
+		if s.i == i {
+			states[j].n += n
+			return states
+		}
+		if s.i > i {
+			states = append(states, state{})
+			copy(states[j+1:], states[j:])
+			states[j] = state{i, n}
+			return states
+		}
+	}
+	return append(states, state{i, n})
+}
+
+// skip reads and discards the next value from dec.
+func skip(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package mach
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/forsyth/jsonpath/paths"
+)
+
+// streamQueries are streamed over the standard "book" example, and should give the same values as Run.
+var streamQueries = []string{
+	"$",
+	"$.store",
+	"$.*",
+	"$.*.*",
+	"$.store.book[1].author",
+	"$.store.book[0,2].title",
+	"$.store.book[1:3].price",
+	"$.store.book[::2].title",
+	"$.store.book[*].author",
+	"$..author",
+	"$..book[2]",
+	"$.store..price",
+	"$..*",
+	"$.store.book[?(@.price < 10)].title",
+	"$.store.book[?(@.isbn)]",
+	"$..book[?(@.author =~ /.*Tolkien/)].price",
+	"$.store['bicycle','book'][0]",
+	"$.nothing..price",
+	"$.store[?(@..color)]",
+	"$.store.book[0,0].title",
+	"$.store.book[1,0:2].title",
+	"$.store['book','book'][0].author",
+	"$..*..author",
+	"$..book[?(@.price > 10)]..*",
+}
+
+// TestStream checks that a Stream selects the same values as the corresponding Program.
+func TestStream(t *testing.T) {
+	js := loadJSON(testJSON, t)
+	for i, q := range streamQueries {
+		path, err := paths.ParsePath(q)
+		if err != nil {
+			t.Fatalf("stream %d: %s: parse: %s", i, q, err)
+		}
+		prog, err := Compile(path)
+		if err != nil {
+			t.Fatalf("stream %d: %s: compile: %s", i, q, err)
+		}
+		stream, err := CompileStream(path)
+		if err != nil {
+			t.Errorf("stream %d: %s: compile stream: %s", i, q, err)
+			continue
+		}
+		vals, err := prog.Run(js)
+		if err != nil {
+			t.Fatalf("stream %d: %s: run: %s", i, q, err)
+		}
+		f, err := os.Open(testJSON)
+		if err != nil {
+			t.Fatalf("stream %d: %s", i, err)
+		}
+		var got []JSON
+		err = stream.Eval(f, func(v JSON) error {
+			got = append(got, v)
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			t.Errorf("stream %d: %s: eval: %s", i, q, err)
+			continue
+		}
+		if a, b := sortedStrings(got), sortedStrings(vals); a != b {
+			t.Errorf("stream %d: %s: got %s, expected %s", i, q, a, b)
+		}
+	}
+}
+
+// sortedStrings returns a canonical text for a list of values, ignoring their order.
+func sortedStrings(vals []JSON) string {
+	s := make([]string, len(vals))
+	for i, v := range vals {
+		s[i] = jsonString(v)
+	}
+	sort.Strings(s)
+	return "[" + strings.Join(s, ",") + "]"
+}
+
+// TestStreamSequence checks streaming of a sequence of JSON values, and the end of evaluation.
+func TestStreamSequence(t *testing.T) {
+	const input = `{"a": 1} {"b": 2} [{"a": 3}] {"a": [4]}` + "\n"
+	stream := mustStream(t, "$.a")
+	var got []JSON
+	err := stream.Eval(strings.NewReader(input), func(v JSON) error {
+		got = append(got, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream sequence: %s", err)
+	}
+	if s := jsonString(got); s != `[1,[4]]` {
+		t.Errorf("stream sequence: got %s, expected [1,[4]]", s)
+	}
+	stop := errors.New("stop")
+	n := 0
+	err = stream.Eval(strings.NewReader(input), func(v JSON) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("stream stop: got (%d, %v), expected (1, %v)", n, err, stop)
+	}
+	err = stream.Eval(strings.NewReader(`{"a": 1}]`), func(JSON) error { return nil })
+	if err == nil {
+		t.Errorf("stream syntax: expected error")
+	}
+}
+
+// TestStreamDecoder checks that EvalDecoder respects the decoder's settings, and visits members in document order.
+func TestStreamDecoder(t *testing.T) {
+	const input = `{"a": [1.5, 12345678901234567890], "o": {"b": 1, "a": 2}}`
+	tests := []struct {
+		path   string
+		expect string // values emitted, with their types
+	}{
+		{"$.a[*]", `[json.Number(1.5) json.Number(12345678901234567890)]`},
+		{"$[?(keys(@)[0] == 'b')].a", `[json.Number(2)]`},
+		{"$[?(@.b)].*", `[json.Number(1) json.Number(2)]`},
+	}
+	for i, st := range tests {
+		stream := mustStream(t, st.path)
+		dec := json.NewDecoder(strings.NewReader(input))
+		dec.UseNumber()
+		var got []string
+		err := stream.EvalDecoder(dec, func(v JSON) error {
+			got = append(got, fmt.Sprintf("%T(%v)", v, v))
+			return nil
+		})
+		if err != nil {
+			t.Errorf("stream decoder %d: %s: eval: %s", i, st.path, err)
+			continue
+		}
+		if s := fmt.Sprint(got); s != st.expect {
+			t.Errorf("stream decoder %d: %s: got %s, expected %s", i, st.path, s, st.expect)
+		}
+	}
+}
+
+// TestNotStreamable checks that paths that cannot be streamed are rejected.
+func TestNotStreamable(t *testing.T) {
+	for i, q := range []string{
+		"$.a[?(@.b == $.c)]",
+		"$..[?(@.b)]",
+		"$.a[-1]",
+		"$.a[-2:]",
+		"$.a[(@.length-1)]",
+		"$.a[*, 0]",
+		"$.a[?(@key == 'b')]",
+		"$..b^",
+		"$.a[?(@.b[?(@ == $.c)])]",
+	} {
+		path, err := paths.ParsePath(q)
+		if err != nil {
+			t.Fatalf("not streamable %d: %s: parse: %s", i, q, err)
+		}
+		_, err = CompileStream(path)
+		if !errors.Is(err, ErrNotStreamable) {
+			t.Errorf("not streamable %d: %s: got %v, expected %s", i, q, err, ErrNotStreamable)
+		}
+	}
+}
+
+func mustStream(t *testing.T, q string) *Stream {
+	path, err := paths.ParsePath(q)
+	if err != nil {
+		t.Fatalf("%s: parse: %s", q, err)
+	}
+	stream, err := CompileStream(path)
+	if err != nil {
+		t.Fatalf("%s: compile stream: %s", q, err)
+	}
+	return stream
+}
@@ -25,10 +25,7 @@ func (prog *Program) String() string {
 		}
 		op := ord.op()
 		sb.WriteString(trimOp(op))
-		if op.IsLeaf() {
-			if !op.HasVal() {
-				continue
-			}
+		if op.IsLeaf() && op.HasVal() {
 			if ord.isSmallInt() {
 				sb.WriteByte('(')
 				sb.WriteString(fmt.Sprint(ord.smallInt()))
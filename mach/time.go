@@ -0,0 +1,94 @@
+package mach
+
+// Time values for the date and time functions, which represent an instant as milliseconds since the Unix epoch,
+// as JavaScript does.
+
+import (
+	"math"
+	"time"
+)
+
+// maxTime is the largest number of milliseconds either side of the epoch in a time value, as for a JavaScript Date.
+const maxTime = 8.64e15
+
+// timeLayout is the default layout of format_time: RFC 3339, with milliseconds if not zero.
+const timeLayout = "2006-01-02T15:04:05.999Z07:00"
+
+// timeUnits gives the length in milliseconds of each unit accepted by date_add and date_diff.
+var timeUnits = map[string]int64{
+	"ms": 1,
+	"s":  1000,
+	"m":  60 * 1000,
+	"h":  60 * 60 * 1000,
+	"d":  24 * 60 * 60 * 1000,
+	"w":  7 * 24 * 60 * 60 * 1000,
+}
+
+// WithClock sets the source of the time returned by now() (by default, time.Now), for instance to make results repeatable.
+func WithClock(clock func() time.Time) Option {
+	return func(p *Program) {
+		p.clock = clock
+	}
+}
+
+// now returns the current time according to the Program's clock, reading it only once in each run,
+// so that all calls of now() in a run agree.
+func (m *machine) now() time.Time {
+	if !m.timeSet {
+		if m.prog.clock == nil {
+			m.time = time.Now()
+		} else {
+			m.time = m.prog.clock()
+		}
+		m.timeSet = true
+	}
+	return m.time
+}
+
+// timeArg returns v as a time value, and true, if it is a number of milliseconds or an RFC 3339 string, within range.
+func timeArg(v JSON) (int64, bool) {
+	switch t := v.(type) {
+	case string:
+		tm, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return 0, false
+		}
+		return timeMillis(tm)
+	default:
+		if !isNumber(t) {
+			return 0, false
+		}
+		ms := cvf(t)
+		if math.IsNaN(ms) || math.Abs(ms) > maxTime {
+			return 0, false
+		}
+		return int64(ms), true
+	}
+}
+
+// timeMillis returns t as a time value, and true, if it is in range.
+func timeMillis(t time.Time) (int64, bool) {
+	ms := t.UnixMilli()
+	if ms < -maxTime || ms > maxTime {
+		return 0, false
+	}
+	return ms, true
+}
+
+// timeResult returns the time value ms (rounded to a whole number of milliseconds), or ErrOverflow if it is out of range.
+func timeResult(ms float64) JSON {
+	if math.IsNaN(ms) || math.Abs(ms) > maxTime {
+		return ErrOverflow
+	}
+	return int64(math.Round(ms))
+}
+
+// unitArg returns the length in milliseconds of the time unit named by v, and true, if there is one.
+func unitArg(v JSON) (int64, bool) {
+	name, ok := v.(string)
+	if !ok {
+		return 0, false
+	}
+	unit, ok := timeUnits[name]
+	return unit, ok
+}
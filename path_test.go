@@ -2,6 +2,7 @@ package jsonpath
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -82,3 +83,32 @@ func codePath(path paths.Path) (string, error) {
 	}
 	return prog.String(), nil
 }
+
+// TestCompileError checks that Compile locates invalid function calls, as for other syntax errors.
+func TestCompileError(t *testing.T) {
+	tests := []struct {
+		expr  string
+		msg   string
+		caret string
+	}{
+		{"$[?(foo(@))]", "call of unknown function: foo at offset 4", "$[?(foo(@))]\n    ^"},
+		{"$[?(@.a &&\n\tabs(@, 1))]", "abs: wrong argument count: need 1, got 2 at offset 12", "\tabs(@, 1))]\n\t^"},
+	}
+	for i, ct := range tests {
+		_, err := Compile(ct.expr)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("compile error %d: %q: got %#v, expected *SyntaxError", i, ct.expr, err)
+			continue
+		}
+		if se.Error() != ct.msg {
+			t.Errorf("compile error %d: %q: got message %q, expected %q", i, ct.expr, se.Error(), ct.msg)
+		}
+		if got := se.Caret(); got != ct.caret {
+			t.Errorf("compile error %d: %q: got caret display\n%s\nexpected\n%s", i, ct.expr, got, ct.caret)
+		}
+		if !errors.Is(err, mach.ErrUnknownFunc) && !errors.Is(err, mach.ErrArgCount) {
+			t.Errorf("compile error %d: %q: got %v, expected ErrUnknownFunc or ErrArgCount", i, ct.expr, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package paths
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError describes an error in the text of a path or expression, and where it was found.
+// Its Error method returns the same text as before the error was given structure,
+// so the location is also given there, as a byte offset, when the diagnostic includes one.
+type SyntaxError struct {
+	Msg      string   // text of the diagnostic
+	Source   string   // the text being parsed
+	Offset   int      // byte offset in Source at which the error was detected
+	Line     int      // line number of Offset, origin 1
+	Column   int      // column of Offset within its line, in characters, origin 1
+	Token    string   // the offending token, if any
+	Expected []string // tokens that would have been accepted instead, if known
+	Err      error    // underlying error (eg, ErrUnclosedString), or nil
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Msg
+}
+
+// Unwrap returns the underlying error, allowing errors.Is(err, ErrUnclosedString) and similar.
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Caret returns the line of the source containing the error, and below it a line with a caret (^)
+// under the character at which the error was detected.
+func (e *SyntaxError) Caret() string {
+	start := strings.LastIndexByte(e.Source[:e.Offset], '\n') + 1
+	end := strings.IndexByte(e.Source[start:], '\n')
+	if end < 0 {
+		end = len(e.Source)
+	} else {
+		end += start
+	}
+	var sb strings.Builder
+	sb.WriteString(e.Source[start:end])
+	sb.WriteByte('\n')
+	for _, c := range e.Source[start:e.Offset] {
+		if c == '\t' {
+			// keep tabs, so the caret lines up however they are displayed
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	sb.WriteByte('^')
+	return sb.String()
+}
+
+// syntaxErr returns a *SyntaxError with the given diagnostic at the current input offset,
+// citing the offending token and those expected instead.
+func (l *lexer) syntaxErr(msg string, tok string, expected ...string) *SyntaxError {
+	return newSyntaxError(l.r.s, l.r.errOffset(), msg, tok, expected, nil)
+}
+
+// wrapErr returns err as a *SyntaxError at the current input offset, if it is not one already.
+func (l *lexer) wrapErr(err error) error {
+	if _, ok := err.(*SyntaxError); ok {
+		return err
+	}
+	return newSyntaxError(l.r.s, l.r.errOffset(), err.Error(), "", nil, err)
+}
+
+// ErrorAt returns err as a *SyntaxError at byte offset in the source s,
+// for an error found after parsing (eg, a call of an unknown function when the path is compiled).
+func ErrorAt(s string, offset int, err error) *SyntaxError {
+	return newSyntaxError(s, offset, fmt.Sprintf("%s at offset %d", err, offset), "", nil, err)
+}
+
+func newSyntaxError(s string, offset int, msg string, tok string, expected []string, err error) *SyntaxError {
+	line := 1 + strings.Count(s[:offset], "\n")
+	start := strings.LastIndexByte(s[:offset], '\n') + 1
+	column := 1 + utf8.RuneCountInString(s[start:offset])
+	return &SyntaxError{Msg: msg, Source: s, Offset: offset, Line: line, Column: column, Token: tok, Expected: expected, Err: err}
+}
+
+// tokens returns the text of each token, for SyntaxError.Expected.
+func tokens(toks ...token) []string {
+	a := make([]string, len(toks))
+	for i, t := range toks {
+		a[i] = t.String()
+	}
+	return a
+}
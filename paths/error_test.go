@@ -0,0 +1,57 @@
+package paths
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type syntaxErrorTest struct {
+	s        string
+	msg      string
+	line     int
+	column   int
+	token    string
+	expected string // space-separated
+	caret    string
+}
+
+var syntaxErrorTests = []syntaxErrorTest{
+	{"$[]", "unexpected ] at offset 2", 1, 3, "]", "* ( : ?( integer literal string literal identifier", "$[]\n  ^"},
+	{"$.'key'", "unexpected string literal at offset 6", 1, 7, "string literal", "* identifier integer literal (", "$.'key'\n      ^"},
+	{"$.a[1:2x]", "unexpected token identifier at offset 7", 1, 8, "identifier", "] , :", "$.a[1:2x]\n       ^"},
+	{"$.a[?(@.b ==\n\t@.c +)]", "unexpected token ) in expression term", 2, 7, ")", "identifier integer literal floating-point literal string literal / @ $ ( [ { - !", "\t@.c +)]\n\t     ^"},
+	{"$['é'.x]", "expected \"]\" at offset 6, got .", 1, 6, ".", "]", "$['é'.x]\n     ^"},
+	{"$[?(@ =~ /a/iu)]", "unsupported regular expression flag 'u' at offset 13", 1, 14, "u", "i m s", "$[?(@ =~ /a/iu)]\n             ^"},
+	{"x", "expected \"$\" at offset 0, got identifier", 1, 1, "identifier", "$", "x\n^"},
+}
+
+func TestSyntaxError(t *testing.T) {
+	for i, st := range syntaxErrorTests {
+		_, err := ParsePath(st.s)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("syntax error %d: %q: got %#v, expected *SyntaxError", i, st.s, err)
+			continue
+		}
+		if se.Error() != st.msg {
+			t.Errorf("syntax error %d: %q: got message %q, expected %q", i, st.s, se.Error(), st.msg)
+		}
+		if se.Line != st.line || se.Column != st.column {
+			t.Errorf("syntax error %d: %q: got %d:%d, expected %d:%d", i, st.s, se.Line, se.Column, st.line, st.column)
+		}
+		if se.Token != st.token {
+			t.Errorf("syntax error %d: %q: got token %q, expected %q", i, st.s, se.Token, st.token)
+		}
+		if got := strings.Join(se.Expected, " "); got != st.expected {
+			t.Errorf("syntax error %d: %q: got expected tokens %q, expected %q", i, st.s, got, st.expected)
+		}
+		if got := se.Caret(); got != st.caret {
+			t.Errorf("syntax error %d: %q: got caret display\n%s\nexpected\n%s", i, st.s, got, st.caret)
+		}
+	}
+	_, err := ParsePath("$['abc")
+	if !errors.Is(err, ErrUnclosedString) {
+		t.Errorf("unclosed string: got %v, expected ErrUnclosedString", err)
+	}
+}
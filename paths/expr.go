@@ -5,6 +5,7 @@ package paths
 import (
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 // Expr represents an arbitrary expression tree; it can be converted to one of the ...Leaf types or Inner, depending on Opcode,
@@ -91,20 +92,46 @@ func (l *NullLeaf) String() string {
 // NameLeaf represents a user-defined name (OpID), "@" (OpCurrent) and "$" (OpRoot) in an Expr tree.
 type NameLeaf struct {
 	Op
-	Name string
+	Name   string
+	Offset int // Offset is the byte offset of the name in the source, for diagnostics.
 }
 
 func (l *NameLeaf) String() string {
 	return l.Name
 }
 
+// QueryLeaf represents a query in an Expr tree: a path starting from "@" or "$" that can select any number of values,
+// because it contains a step such as "..", a wildcard, slice, union or filter.
+// A query that can select at most one value (eg, @.a[0]) is instead represented by OpDot and OpIndex operators.
+type QueryLeaf struct {
+	Op
+	Root Op   // Root is OpCurrent or OpRoot, the starting point.
+	Path Path // Path is the sequence of steps from Root.
+}
+
+func (l *QueryLeaf) String() string {
+	var sb strings.Builder
+	sb.WriteString("Query(")
+	sb.WriteString(l.Root.String())
+	for _, step := range l.Path {
+		sb.WriteByte(' ')
+		sb.WriteString(step.String())
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
 // RegexpLeaf represents the text of a regular expression in an Expr tree.
 type RegexpLeaf struct {
 	Op
 	Pattern string         // Pattern is the text of the expression.
-	Prog    *regexp.Regexp // Prog is the compiled version of the same.
+	Flags   string         // Flags are the flags that followed it (any of i, m and s), or "".
+	Prog    *regexp.Regexp // Prog is the compiled version of the same, with the flags applied.
 }
 
 func (l *RegexpLeaf) String() string {
+	if l.Flags != "" {
+		return fmt.Sprintf("Regexp(%q, %s)", l.Pattern, l.Flags)
+	}
 	return fmt.Sprintf("Regexp(%q)", l.Pattern)
 }
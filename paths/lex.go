@@ -16,6 +16,8 @@ var (
 	ErrIntOverflow    = errors.New("overflow of negative integer literal")
 	ErrBadReal        = errors.New("invalid floating-point literal syntax")
 	ErrCtrlChar       = errors.New("must use escape to encode ctrl character")
+	ErrLeadingZero    = errors.New("leading zero in number")
+	ErrNegativeZero   = errors.New("-0 is not an integer")
 )
 
 // lexeme is a tuple representing a lexical element: token, optional value, optional error
@@ -65,13 +67,14 @@ func (lx lexeme) f() float64 {
 
 // lexer provides state and one-token lookahead for the token stream
 type lexer struct {
-	r    *rd
-	peek bool   // unget was called
-	lex  lexeme // value of unget
+	r       *rd
+	peek    bool    // unget was called
+	lex     lexeme  // value of unget
+	dialect Dialect // grammar being parsed
 }
 
-func newLexer(r *rd) *lexer {
-	return &lexer{r: r}
+func newLexer(r *rd, dialect Dialect) *lexer {
+	return &lexer{r: r, dialect: dialect}
 }
 
 // unget saves a lexeme.
@@ -97,9 +100,9 @@ func (l *lexer) offset() string {
 	return l.r.offset()
 }
 
-// lexErr returns a lexeme that bundles a diagnostic.
+// lexErr returns a lexeme that bundles a diagnostic, as a *SyntaxError.
 func (l *lexer) lexErr(err error) lexeme {
-	return lexeme{tokError, nil, err}
+	return lexeme{tokError, nil, l.wrapErr(err)}
 }
 
 // ws skips white space, and returns the current location
@@ -111,7 +114,9 @@ func (l *lexer) ws() Loc {
 }
 
 // lexNumber returns an integer token from r with a 64-bit value, or an error (eg, it overflows).
-// The IETF grammar excludes leading zeroes, presumably to avoid octal, but we'll accept them as decimal.
+// If real is true, a number with a fraction or exponent is instead a floating-point token.
+// The IETF grammar excludes leading zeroes, presumably to avoid octal, but by default we'll accept them as decimal,
+// and an exponent only after a fraction. The RFC9535 dialect follows the RFC: int [frac] [exp], without leading zeroes.
 func (l *lexer) lexNumber(real bool) lexeme {
 	var sb strings.Builder
 	r := l.r
@@ -119,7 +124,13 @@ func (l *lexer) lexNumber(real bool) lexeme {
 	for isDigit(r.look()) {
 		sb.WriteByte(byte(r.get()))
 	}
-	if !real || r.look() != '.' {
+	rfc := l.dialect == RFC9535
+	if rfc && sb.Len() > 1 && sb.String()[0] == '0' {
+		return l.lexErr(ErrLeadingZero)
+	}
+	frac := real && r.look() == '.'
+	exp := real && rfc && (r.look() == 'e' || r.look() == 'E')
+	if !frac && !exp {
 		// integer only
 		v, err := strconv.ParseInt(sb.String(), 10, 64)
 		if err != nil {
@@ -127,9 +138,15 @@ func (l *lexer) lexNumber(real bool) lexeme {
 		}
 		return lexeme{tokInt, v, nil}
 	}
-	sb.WriteByte(byte(r.get()))
-	for isDigit(r.look()) {
+	if frac {
 		sb.WriteByte(byte(r.get()))
+		if rfc && !isDigit(r.look()) {
+			// frac = "." 1*DIGIT
+			return l.lexErr(ErrBadReal)
+		}
+		for isDigit(r.look()) {
+			sb.WriteByte(byte(r.get()))
+		}
 	}
 	if r.look() == 'e' || r.look() == 'E' { // e[+-]?[0-9]+
 		sb.WriteByte(byte(r.get()))
@@ -274,14 +291,23 @@ func (l *lexer) isNext(c int, t token, f token) lexeme {
 		return lexeme{t, nil, nil}
 	}
 	if f == tokError {
-		return l.lexErr(fmt.Errorf("unexpected char %q after %q at %s", rune(r.look()), rune(c), r.offset()))
+		msg := fmt.Sprintf("unexpected char %q after %q at %s", rune(r.look()), rune(c), r.offset())
+		return l.lexErr(l.syntaxErr(msg, charText(r.look()), string(rune(c))))
 	}
 	return lexeme{f, nil, nil}
 }
 
 // diagnose an unexpected character, not valid for a token
 func (l *lexer) tokenErr(c int) lexeme {
-	return l.lexErr(fmt.Errorf("unexpected character %q at %s", rune(c), l.r.offset()))
+	return l.lexErr(l.syntaxErr(fmt.Sprintf("unexpected character %q at %s", rune(c), l.r.offset()), charText(c)))
+}
+
+// charText returns the text of input unit c, for a SyntaxError.
+func charText(c int) string {
+	if c == eof {
+		return tokEOF.String()
+	}
+	return string(rune(c))
 }
 
 func isDigit(c int) bool {
@@ -51,6 +51,9 @@ var samples = []lexOutput{
 	lexOutput{"$[':@.\"$,*\\\\'\\\\\\\\']",
 		[]string{"$", "[", "tokString:\":@.\\\"$,*\\\\\"", "tokError:unexpected character '\\\\' at offset 13"},
 	},
+	lexOutput{"$[01, - 1, -0]",
+		[]string{"$", "[", "tokInt:1", ",", "tokInt:-1", ",", "tokInt:0", "]", "tokEOF"},
+	},
 	lexOutput{"(1.5 + 31.0e-1)",
 		[]string{"(", "tokReal:1.5", "+", "tokReal:3.1", ")"},
 	},
@@ -1,7 +1,9 @@
 package paths
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"strings"
 )
 
@@ -21,7 +23,7 @@ func (l *lexer) lexExpr() lexeme {
 	switch c := r.get(); c {
 	case eof:
 		return lexeme{tokEOF, nil, nil}
-	case '(', ')', '[', ']', '@', '$', '.', ',', '~', '*', '%', '+', '-':
+	case '(', ')', '[', ']', '{', '}', '@', '$', '.', ',', ':', '~', '*', '%', '+', '-':
 		return lexeme{token(c), nil, nil}
 	case '/':
 		return lexeme{token(c), nil, nil}
@@ -41,6 +43,8 @@ func (l *lexer) lexExpr() lexeme {
 		return l.isNext('~', tokMatch, '=')
 	case '!':
 		return l.isNext('=', tokNE, '!')
+	case '?':
+		return l.isNext('?', tokCoalesce, '?')
 	case '"', '\'':
 		s, err := l.lexString(c)
 		if err != nil {
@@ -68,7 +72,7 @@ func (l *lexer) lexRegexp(ec int) lexeme {
 		c := r.get()
 		switch {
 		case c == eof:
-			return lexeme{tokRE, s.String(), ErrUnclosedRE}
+			return lexeme{tokRE, s.String(), l.wrapErr(ErrUnclosedRE)}
 		case c == ec:
 			return lexeme{tokRE, s.String(), nil}
 		case c == '\\' && r.look() == ec:
@@ -78,3 +82,25 @@ func (l *lexer) lexRegexp(ec int) lexeme {
 		s.WriteByte(byte(c))
 	}
 }
+
+// lexRegexpFlags collects the flags that can follow a regular expression literal, as in /smith/i.
+// The JavaScript flags i (ignore case), m (multi-line) and s (. matches newline) have the same meaning in Go,
+// and the result is the set of them, once each, in the order given.
+// Other flags (eg, g and u) are rejected.
+func (l *lexer) lexRegexpFlags() (string, error) {
+	var flags []byte
+	r := l.r
+	for isLetter(r.look()) {
+		c := r.get()
+		switch c {
+		case 'i', 'm', 's':
+			if bytes.IndexByte(flags, byte(c)) < 0 {
+				flags = append(flags, byte(c))
+			}
+		default:
+			msg := fmt.Sprintf("unsupported regular expression flag %q at %s", rune(c), r.offset())
+			return "", l.syntaxErr(msg, charText(c), "i", "m", "s")
+		}
+	}
+	return string(flags), nil
+}
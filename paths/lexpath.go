@@ -14,11 +14,15 @@ func (l *lexer) lexPath() lexeme {
 	switch c := r.get(); c {
 	case eof:
 		return lexeme{tokEOF, nil, nil}
-	case '(', ')', '[', ']', '*', '$', ':', ',':
+	case '(', ')', '[', ']', '*', '$', ':', ',', '^', '~':
 		return lexeme{token(c), nil, nil}
 	case '.':
 		return l.isNext('.', tokNest, '.')
 	case '?':
+		if l.dialect == RFC9535 {
+			// the filter's logical-expr need not be parenthesised
+			return lexeme{token(c), nil, nil}
+		}
 		return l.isNext('(', tokFilter, tokError)
 	case '"', '\'':
 		s, err := l.lexString(c)
@@ -27,16 +31,25 @@ func (l *lexer) lexPath() lexeme {
 		}
 		return lexeme{tokString, s, err}
 	case '-': // - is allowed as a sign for integers
-		l.ws()
+		if l.dialect != RFC9535 {
+			// the RFC's int has the sign next to the digits
+			l.ws()
+		}
 		if !isDigit(r.get()) {
 			r.unget()
 			return l.tokenErr(r.look())
 		}
 		fol := l.lexNumber(false)
+		if fol.tok == tokError {
+			return fol
+		}
 		if fol.tok != tokInt {
 			return l.tokenErr(c)
 		}
 		n := fol.val.(int64)
+		if n == 0 && l.dialect == RFC9535 {
+			return l.lexErr(ErrNegativeZero)
+		}
 		if n == math.MaxInt64 {
 			return l.lexErr(ErrIntOverflow)
 		}
@@ -47,6 +60,9 @@ func (l *lexer) lexPath() lexeme {
 			return l.lexNumber(false)
 		}
 		if isLetter(c) {
+			if l.dialect == RFC9535 {
+				return l.lexID(isAlphanumeric)
+			}
 			return l.lexID(isAlphanumericDash)
 		}
 		return l.tokenErr(c)
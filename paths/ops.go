@@ -11,6 +11,8 @@ package paths
 // OpNest similarly marks the start of an iteration over the results of the
 // recursive walk specified by "..", and sets up machine state to consider
 // each value from the substructure as it is produced by the walk.
+// OpUnionFor marks the start of a union whose selectors include a wildcard or filter,
+// which applies each selector in turn to each value in the current output set.
 
 type Op int
 
@@ -71,6 +73,27 @@ const (
 	OpNin     // "nin", not in
 	OpMatch   // =~ (why not just ~)
 	OpNot     // unary !
+
+	// operators added since, kept at the end so existing values do not change
+	OpExists      // test for existence of a query result (RFC 9535)
+	OpUnionFor    // start of a union with a wildcard or filter, applying each selector to each value in turn
+	OpUnionNext   // end of one selector of such a union, keeping its results
+	OpUnionRep    // end of such a union, repeating its selectors if values are left
+	OpParent      // ^ (the object or array containing the value)
+	OpKey         // ~ (the member name or index of the value)
+	OpCurrentKey  // @key (member name or array index of current candidate)
+	OpCurrentPath // @path (normalized path of current candidate)
+	OpQuery       // query in an expression, selecting a list of values
+	OpQueryEnd    // end of query: the values it selected become an operand
+	OpObject      // {key: e, ...}
+	OpCoalesce    // ?? (right operand only if left is null or nothing)
+	OpCond        // e ? e : e
+	OpJump        // unconditional branch, skipping part of an expression
+	OpAnyof       // "anyof", arrays have a value in common
+	OpNoneof      // "noneof", arrays have no value in common
+	OpSubsetof    // "subsetof", every value of one array is in the other
+	OpSize        // "size", length of array or string
+	OpEmpty       // "empty", whether an array or string is empty
 )
 
 var opNames = map[Op]string{
@@ -122,6 +145,26 @@ var opNames = map[Op]string{
 	OpNin:        "OpNin",
 	OpMatch:      "OpMatch",
 	OpNot:        "OpNot",
+
+	OpExists:      "OpExists",
+	OpUnionFor:    "OpUnionFor",
+	OpUnionNext:   "OpUnionNext",
+	OpUnionRep:    "OpUnionRep",
+	OpParent:      "OpParent",
+	OpKey:         "OpKey",
+	OpCurrentKey:  "OpCurrentKey",
+	OpCurrentPath: "OpCurrentPath",
+	OpQuery:       "OpQuery",
+	OpQueryEnd:    "OpQueryEnd",
+	OpObject:      "OpObject",
+	OpCoalesce:    "OpCoalesce",
+	OpCond:        "OpCond",
+	OpJump:        "OpJump",
+	OpAnyof:       "OpAnyof",
+	OpNoneof:      "OpNoneof",
+	OpSubsetof:    "OpSubsetof",
+	OpSize:        "OpSize",
+	OpEmpty:       "OpEmpty",
 }
 
 var opText = map[Op]string{
@@ -173,6 +216,26 @@ var opText = map[Op]string{
 	OpNin:        "nin",
 	OpMatch:      "~",
 	OpNot:        "!",
+
+	OpExists:      "existence test",
+	OpUnionFor:    "union start",
+	OpUnionNext:   "union selector end",
+	OpUnionRep:    "union end",
+	OpParent:      "^",
+	OpKey:         "~",
+	OpCurrentKey:  "@key",
+	OpCurrentPath: "@path",
+	OpQuery:       "query",
+	OpQueryEnd:    "query end",
+	OpObject:      "object value",
+	OpCoalesce:    "??",
+	OpCond:        "?:",
+	OpJump:        "jump",
+	OpAnyof:       "anyof",
+	OpNoneof:      "noneof",
+	OpSubsetof:    "subsetof",
+	OpSize:        "size",
+	OpEmpty:       "empty",
 }
 
 // GoString returns the internal name of Op o, for debugging.
@@ -193,7 +256,7 @@ func (o Op) Opcode() Op {
 // IsLeaf returns true if o is a leaf operator.
 func (o Op) IsLeaf() bool {
 	switch o {
-	case OpID, OpString, OpInt, OpBool, OpReal, OpRE, OpNull, OpRoot, OpCurrent, OpWild, OpBounds:
+	case OpID, OpString, OpInt, OpBool, OpReal, OpRE, OpNull, OpRoot, OpCurrent, OpCurrentKey, OpCurrentPath, OpWild, OpBounds, OpQuery:
 		return true
 	default:
 		return false
@@ -211,17 +274,19 @@ func (o Op) HasVal() bool {
 }
 
 // precedence returns a binary operator's precedence, or -1 if it's not a binary operator.
+// The conditional operator ?: has lower precedence than any of them, and is handled separately by the parser.
+// As in JavaScript, ?? has the same precedence as ||.
 // OpMatch (~, =~) is given the same precedence here as a relational operator,
 // although some implementations put it below OpMul.
 func (op Op) precedence() int {
 	switch op {
-	case OpOr:
+	case OpOr, OpCoalesce:
 		return 0
 	case OpAnd:
 		return 1
 	case OpEQ, OpNE:
 		return 2
-	case OpLT, OpLE, OpGT, OpGE, OpMatch, OpIn, OpNin:
+	case OpLT, OpLE, OpGT, OpGE, OpMatch, OpIn, OpNin, OpAnyof, OpNoneof, OpSubsetof, OpSize, OpEmpty:
 		return 3
 	case OpAdd, OpSub:
 		return 4
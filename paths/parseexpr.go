@@ -12,14 +12,14 @@ import (
 // ParseScriptExpression gives direct access to the secondary parser for expressions, returning an Expr tree representing
 // the expression in s.
 func ParseScriptExpression(s string) (Expr, error) {
-	p := newParser(s)
+	p := newParser(s, Parker)
 	e, err := p.parseScriptExpr()
 	if err != nil {
 		return nil, err
 	}
 	lx := p.lexExpr()
 	if lx.tok != tokEOF {
-		return nil, fmt.Errorf("missing operator at %s, before %s", p.offset(), lx.tok)
+		return nil, p.syntaxErr(fmt.Sprintf("missing operator at %s, before %s", p.offset(), lx.tok), lx.tok.String())
 	}
 	return e, nil
 }
@@ -29,6 +29,11 @@ func (p *parser) lookExpr() token {
 	return p.look(p.lexExpr())
 }
 
+// nameOffset returns the byte offset in the source of the name just read.
+func (p *parser) nameOffset(name string) int {
+	return p.r.pos - len(name)
+}
+
 // lexOp looks in the expression lexical syntax for infix operators and keywords.
 func (p *parser) lexOp() lexeme {
 	lx := p.lexExpr()
@@ -41,6 +46,16 @@ func (p *parser) lexOp() lexeme {
 			lx.tok = tokIn
 		case "nin":
 			lx.tok = tokNin
+		case "anyof":
+			lx.tok = tokAnyof
+		case "noneof":
+			lx.tok = tokNoneof
+		case "subsetof":
+			lx.tok = tokSubsetof
+		case "size":
+			lx.tok = tokSize
+		case "empty":
+			lx.tok = tokEmpty
 		}
 	}
 	return lx
@@ -68,7 +83,9 @@ func (p *parser) parseScriptExpr() (Expr, error) {
 //	primary (op e)*
 //
 // See http://antlr.org/papers/Clarke-expr-parsing-1986.pdf for the history and details.
-// p.expr(0) builds a complete (sub)tree.
+// p.expr(0) builds a complete (sub)tree, including a conditional:
+//
+//	e "?" e ":" e
 func (p *parser) expr(pri int) (Expr, error) {
 	e, err := p.primary()
 	if err != nil {
@@ -88,6 +105,23 @@ func (p *parser) expr(pri int) (Expr, error) {
 		}
 		e = &Inner{op, []Expr{e, right}}
 	}
+	if pri == 0 && p.lookExpr() == '?' {
+		// lowest priority, and right-associative
+		p.advanceExpr()
+		then, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect(p.lexExpr, ':')
+		if err != nil {
+			return nil, err
+		}
+		els, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		e = &Inner{OpCond, []Expr{e, then, els}}
+	}
 	return e, nil
 }
 
@@ -102,6 +136,8 @@ func (p *parser) unary(op Op) (Expr, error) {
 }
 
 // primary ::= primary1 ("(" e-list ")" | "[" e "]" | "." identifier)*
+// A primary that is a query (starting with "@" or "$") can also continue with the other steps of a path,
+// such as "..", wildcards, slices, unions and filters, making it a QueryLeaf that selects any number of values.
 func (p *parser) primary() (Expr, error) {
 	e, err := p.primary1()
 	if err != nil {
@@ -112,7 +148,7 @@ func (p *parser) primary() (Expr, error) {
 		case '(':
 			// function call
 			if e.Opcode() != OpID {
-				return nil, fmt.Errorf("expected identifier before '(', not %v", e.Opcode())
+				return nil, p.syntaxErr(fmt.Sprintf("expected identifier before '(', not %v", e.Opcode()), "(", tokID.String())
 			}
 			p.advanceExpr()
 			e, err = p.application(OpCall, ')', e)
@@ -120,32 +156,179 @@ func (p *parser) primary() (Expr, error) {
 				return nil, err
 			}
 		case '[':
-			// index, just one expression
+			// index, or a subscript in a query
 			p.advanceExpr()
-			index, err := p.expr(0)
+			e, err = p.subscript(e)
 			if err != nil {
 				return nil, err
 			}
-			err = p.expect(p.lexExpr, ']')
+		case '.':
+			// field selection, or a step in a query
+			p.advanceExpr()
+			e, err = p.selection(e)
 			if err != nil {
 				return nil, err
 			}
-			e = &Inner{OpIndex, []Expr{e, index}}
-		case '.':
-			// field selection
+		default:
+			return e, nil
+		}
+	}
+}
+
+// selection parses the rest of "." identifier applied to e,
+// or when e is a query, "." "*" and ".." member or ".." "[" subscript "]".
+func (p *parser) selection(e Expr) (Expr, error) {
+	switch p.lookExpr() {
+	case '*':
+		p.advanceExpr()
+		return p.queryStep(e, &Step{OpWild, nil})
+	case '.':
+		p.advanceExpr()
+		step, err := p.parseNest()
+		if err != nil {
+			return nil, err
+		}
+		return p.queryStep(e, step)
+	}
+	lx := p.lexExpr()
+	if lx.err != nil {
+		return nil, lx.err
+	}
+	if lx.tok != tokID {
+		return nil, p.syntaxErr("expected identifier in '.' selection", lx.tok.String(), tokID.String(), "*", ".")
+	}
+	if q, ok := e.(*QueryLeaf); ok {
+		return p.queryStep(q, &Step{OpMember, []Val{NameVal(lx.s())}})
+	}
+	return &Inner{OpDot, []Expr{e, &NameLeaf{OpID, lx.s(), p.nameOffset(lx.s())}}}, nil
+}
+
+// subscript parses the rest of "[" e "]" applied to e, or when e is a query, "[" subscript "]" as in a path,
+// where the subscript can also be "*", a filter, a slice or a union.
+func (p *parser) subscript(e Expr) (Expr, error) {
+	p.ws()
+	switch p.r.look() {
+	case '*', '?', ':':
+		// only in a path
+		step, err := p.parseBrackets()
+		if err != nil {
+			return nil, err
+		}
+		return p.queryStep(e, step)
+	}
+	index, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	switch p.lookExpr() {
+	case ':', ',':
+		// slice or union, only in a path
+		var first *Step
+		val := indexVal(index)
+		if p.lookExpr() == ':' {
 			p.advanceExpr()
-			lx := p.lexExpr()
-			if lx.err != nil {
-				return nil, lx.err
+			first, err = p.parseSlice(val)
+			if err != nil {
+				return nil, err
 			}
-			if lx.tok != tokID {
-				return nil, fmt.Errorf("expected identifier in '.' selection")
+		} else {
+			first = &Step{valOp(val), []Val{val}}
+		}
+		steps := []*Step{first}
+		if p.lookPath() == ',' {
+			p.lexPath()
+			more, err := p.parseValList()
+			if err != nil {
+				return nil, err
 			}
-			e = &Inner{OpDot, []Expr{e, &NameLeaf{OpID, lx.s()}}}
-		default:
-			return e, nil
+			steps = append(steps, more...)
+		}
+		step, err := p.subscriptStep(steps)
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect(p.lexPath, ']')
+		if err != nil {
+			return nil, err
+		}
+		return p.queryStep(e, step)
+	}
+	err = p.expect(p.lexExpr, ']')
+	if err != nil {
+		return nil, err
+	}
+	if q, ok := e.(*QueryLeaf); ok {
+		return p.queryStep(q, &Step{OpSelect, []Val{indexVal(index)}})
+	}
+	return &Inner{OpIndex, []Expr{e, index}}, nil
+}
+
+// queryStep returns the query e extended by step.
+// If e is not yet a QueryLeaf, it must be a singular query ("@" or "$" followed by member and index selections),
+// which is converted to one.
+func (p *parser) queryStep(e Expr, step *Step) (Expr, error) {
+	q, ok := e.(*QueryLeaf)
+	if !ok {
+		q, ok = toQuery(e)
+		if !ok {
+			return nil, p.syntaxErr(fmt.Sprintf("%s selection must be in a query from @ or $, at %s", step.Op, p.offset()), step.Op.String(), "@", "$")
+		}
+	}
+	q.Path = append(q.Path, step)
+	return q, nil
+}
+
+// toQuery converts a singular query in expression form to a QueryLeaf, returning false if e is not one.
+func toQuery(e Expr) (*QueryLeaf, bool) {
+	switch e.Opcode() {
+	case OpCurrent, OpRoot:
+		return &QueryLeaf{OpQuery, e.Opcode(), Path{}}, true
+	case OpDot:
+		t := e.(*Inner)
+		q, ok := toQuery(t.Kids[0])
+		if !ok {
+			return nil, false
+		}
+		q.Path = append(q.Path, &Step{OpMember, []Val{NameVal(t.Kids[1].(*NameLeaf).Name)}})
+		return q, true
+	case OpIndex:
+		t := e.(*Inner)
+		q, ok := toQuery(t.Kids[0])
+		if !ok {
+			return nil, false
+		}
+		q.Path = append(q.Path, &Step{OpSelect, []Val{indexVal(t.Kids[1])}})
+		return q, true
+	default:
+		return nil, false
+	}
+}
+
+// indexVal returns the Val for an index expression in a query: an integer or string constant, or the expression itself.
+func indexVal(e Expr) Val {
+	switch e := e.(type) {
+	case *IntLeaf:
+		return IntVal(e.Val)
+	case *StringLeaf:
+		return StringVal(e.Val)
+	case *Inner:
+		if n, ok := e.Kids[0].(*IntLeaf); ok && e.Op == OpNeg {
+			return IntVal(-n.Val)
 		}
 	}
+	return e
+}
+
+// valOp returns the Op that tags a union element with value v, as parseVal does.
+func valOp(v Val) Op {
+	switch v.(type) {
+	case IntVal:
+		return OpInt
+	case StringVal:
+		return OpString
+	default:
+		return OpExp
+	}
 }
 
 // apply optional expression e to an expression list (terminated by a given end token) as operator op
@@ -184,7 +367,48 @@ func (p *parser) parseExprList(list []Expr) ([]Expr, error) {
 	}
 }
 
-// primary1 ::= identifier | integer | real | string | "/" re "/" | "@" | "$" | "(" expr ")" | "[" e-list "]" | "-" primary1 | "!" primary1
+// object-members ::= object-member ("," object-member)*
+// object-member ::= (string | identifier) ":" expr
+// The result has the name of each member as a string, followed by its value.
+func (p *parser) object() (Expr, error) {
+	kids := []Expr{}
+	if p.lookExpr() == '}' {
+		p.advanceExpr()
+		return &Inner{OpObject, kids}, nil
+	}
+	for {
+		lx := p.lexExpr()
+		if lx.err != nil {
+			return nil, lx.err
+		}
+		if lx.tok != tokString && lx.tok != tokID {
+			return nil, p.syntaxErr(fmt.Sprintf("expected member name in object, got %v at %s", lx.tok, p.offset()), lx.tok.String(), tokens(tokString, tokID)...)
+		}
+		err := p.expect(p.lexExpr, ':')
+		if err != nil {
+			return nil, err
+		}
+		e, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		kids = append(kids, &StringLeaf{OpString, lx.s()}, e)
+		if p.lookExpr() != ',' {
+			break
+		}
+		p.advanceExpr()
+	}
+	err := p.expect(p.lexExpr, '}')
+	if err != nil {
+		return nil, err
+	}
+	return &Inner{OpObject, kids}, nil
+}
+
+// termStart lists the tokens that can start a primary1, for diagnostics.
+var termStart = tokens(tokID, tokInt, tokReal, tokString, '/', '@', '$', '(', '[', '{', '-', '!')
+
+// primary1 ::= identifier | integer | real | string | "/" re "/" re-flags? | "@" | "@key" | "@path" | "$" | "(" expr ")" | "[" e-list "]" | "{" object-members? "}" | "-" primary1 | "!" primary1
 func (p *parser) primary1() (Expr, error) {
 	lx := p.lexExpr()
 	if lx.err != nil {
@@ -206,7 +430,7 @@ func (p *parser) primary1() (Expr, error) {
 		case "null":
 			return &NullLeaf{OpNull}, nil
 		default:
-			return &NameLeaf{OpID, id}, nil
+			return &NameLeaf{OpID, id, p.nameOffset(id)}, nil
 		}
 	case tokInt:
 		return &IntLeaf{OpInt, lx.i()}, nil
@@ -215,20 +439,40 @@ func (p *parser) primary1() (Expr, error) {
 	case tokString:
 		return &StringLeaf{OpString, lx.s()}, nil
 	case '/':
-		off := p.offset()
+		off := p.r.errOffset()
 		lx = p.lexRegexp('/')
 		if lx.err != nil {
 			return nil, lx.err
 		}
-		prog, err := regexp.Compile(lx.s())
+		flags, err := p.lexRegexpFlags()
 		if err != nil {
-			return nil, fmt.Errorf("%s at %s", err, off)
+			return nil, err
+		}
+		re := lx.s()
+		if flags != "" {
+			re = "(?" + flags + ")" + re
+		}
+		prog, err := regexp.Compile(re)
+		if err != nil {
+			return nil, newSyntaxError(p.r.s, off, fmt.Sprintf("%s at offset %d", err, off), tokRE.String(), nil, err)
 		}
-		return &RegexpLeaf{OpRE, lx.s(), prog}, nil
+		return &RegexpLeaf{OpRE, lx.s(), flags, prog}, nil
 	case '@':
-		return &NameLeaf{OpCurrent, "@"}, nil
+		if isLetter(p.r.look()) {
+			// @key or @path, with no space after @
+			lx = p.lexExpr()
+			switch lx.s() {
+			case "key":
+				return &NameLeaf{OpCurrentKey, "@key", p.nameOffset("@key")}, nil
+			case "path":
+				return &NameLeaf{OpCurrentPath, "@path", p.nameOffset("@path")}, nil
+			default:
+				return nil, p.syntaxErr(fmt.Sprintf("unknown name @%s at %s", lx.s(), p.offset()), lx.s(), "key", "path")
+			}
+		}
+		return &NameLeaf{OpCurrent, "@", p.nameOffset("@")}, nil
 	case '$':
-		return &NameLeaf{OpRoot, "$"}, nil
+		return &NameLeaf{OpRoot, "$", p.nameOffset("$")}, nil
 	case '(':
 		e, err := p.parseScriptExpr()
 		if err != nil {
@@ -242,8 +486,11 @@ func (p *parser) primary1() (Expr, error) {
 	case '[':
 		// array-literal
 		return p.application(OpArray, ']', nil)
+	case '{':
+		// object-literal
+		return p.object()
 	default:
-		return nil, fmt.Errorf("unexpected token %v in expression term", lx.tok)
+		return nil, p.syntaxErr(fmt.Sprintf("unexpected token %v in expression term", lx.tok), lx.tok.String(), termStart...)
 	}
 }
 
@@ -282,6 +529,18 @@ func tok2op(t token) Op {
 		return OpIn
 	case tokNin:
 		return OpNin
+	case tokAnyof:
+		return OpAnyof
+	case tokNoneof:
+		return OpNoneof
+	case tokSubsetof:
+		return OpSubsetof
+	case tokSize:
+		return OpSize
+	case tokEmpty:
+		return OpEmpty
+	case tokCoalesce:
+		return OpCoalesce
 	default:
 		return OpError
 	}
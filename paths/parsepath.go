@@ -5,9 +5,10 @@ import (
 )
 
 // path ::= "$" step*
-// step ::= "." member | ".." member | "[" subscript "]" | ".." "[" subscript "]"
+// step ::= "." member | ".." member | "[" subscript "]" | ".." "[" subscript "]" | "^" | "~"
 // member ::= "*" | identifier | expr | signed-integer
-// subscript ::= subscript-expression | union-element ("," union-element)
+// subscript ::= selector ("," selector)*
+// selector ::= subscript-expression | union-element
 // subscript-expression ::= "*" | expr | filter
 // union-element ::=  array-index | string-literal | array-slice
 // array-index ::= signed-integer
@@ -22,7 +23,12 @@ import (
 
 // ParsePath returns the parsed form of the path expression in s, or an error.
 func ParsePath(s string) (Path, error) {
-	return newParser(s).parsePath()
+	return newParser(s, Parker).parsePath()
+}
+
+// ParsePathDialect is like ParsePath but accepts the grammar of the given dialect.
+func ParsePathDialect(s string, dialect Dialect) (Path, error) {
+	return newParser(s, dialect).parsePath()
 }
 
 func (p *parser) lookPath() token {
@@ -30,7 +36,7 @@ func (p *parser) lookPath() token {
 }
 
 // path ::= "$" step*
-// step ::= "." member | ".." member | "[" subscript "]" | ".." "[" subscript "]"
+// step ::= "." member | ".." member | "[" subscript "]" | ".." "[" subscript "]" | "^" | "~"
 func (p *parser) parsePath() (Path, error) {
 	err := p.expect(p.lexPath, '$')
 	if err != nil {
@@ -55,52 +61,71 @@ func (p *parser) parsePath() (Path, error) {
 				path = append(path, &Step{OpMember, []Val{name}})
 			}
 		case tokNest:
-			if p.lookPath() == '[' {
-				// ".." "[" subscript "]"
-				p.lexPath()
-				sub, err := p.parseBrackets()
-				if err != nil {
-					return nil, err
-				}
-				var op Op
-				switch sub.Op {
-				case OpSelect, OpExp:
-					op = OpNestSelect
-				case OpUnion:
-					op = OpNestUnion
-				case OpWild:
-					op = OpNestWild
-				case OpFilter:
-					op = OpNestFilter
-				default:
-					panic(fmt.Sprintf("unexpected Nest Op %#v", sub.Op))
-				}
-				sub.Op = op
-				path = append(path, sub)
-				break
-			}
-			op, name, err := p.parseMember()
+			step, err := p.parseNest()
 			if err != nil {
 				return nil, err
 			}
-			if op == OpWild {
-				// $..* is allowed
-				path = append(path, &Step{OpNestWild, nil})
-				break
-			}
-			path = append(path, &Step{OpNestMember, []Val{name}})
+			path = append(path, step)
 		case '[':
 			sub, err := p.parseBrackets()
 			if err != nil {
 				return nil, err
 			}
 			path = append(path, sub)
+		case '^':
+			// parent of the value
+			if p.dialect == RFC9535 {
+				return nil, p.rfcErr("parent operator")
+			}
+			path = append(path, &Step{OpParent, nil})
+		case '~':
+			// name (or index) of the value in its parent
+			if p.dialect == RFC9535 {
+				return nil, p.rfcErr("member name operator")
+			}
+			path = append(path, &Step{OpKey, nil})
 		default:
-			return nil, fmt.Errorf("unexpected token %v", lx)
+			return nil, p.syntaxErr(fmt.Sprintf("unexpected token %v", lx), lx.tok.String(), tokens('.', tokNest, '[', '^', '~', tokEOF)...)
 		}
 	}
 }
 
+// parseNest parses the member or bracketed subscript following "..", returning the corresponding OpNest* step.
+func (p *parser) parseNest() (*Step, error) {
+	if p.lookPath() == '[' {
+		// ".." "[" subscript "]"
+		p.lexPath()
+		sub, err := p.parseBrackets()
+		if err != nil {
+			return nil, err
+		}
+		var op Op
+		switch sub.Op {
+		case OpSelect, OpExp:
+			op = OpNestSelect
+		case OpUnion:
+			op = OpNestUnion
+		case OpWild:
+			op = OpNestWild
+		case OpFilter:
+			op = OpNestFilter
+		default:
+			panic(fmt.Sprintf("unexpected Nest Op %#v", sub.Op))
+		}
+		sub.Op = op
+		return sub, nil
+	}
+	op, name, err := p.parseMember()
+	if err != nil {
+		return nil, err
+	}
+	if op == OpWild {
+		// $..* is allowed
+		return &Step{OpNestWild, nil}, nil
+	}
+	return &Step{OpNestMember, []Val{name}}, nil
+}
+
 // parse bracketed subscript in
 // step ::= ...  "[" subscript "]" ... | ".." "[" subscript "]"
 func (p *parser) parseBrackets() (*Step, error) {
@@ -115,24 +140,32 @@ func (p *parser) parseBrackets() (*Step, error) {
 	return step, nil
 }
 
-// subscript ::= subscript-expression | union-element ("," union-element)
+// subscript ::= selector ("," selector)*
+// selector ::= subscript-expression | union-element
 // subscript-expression ::= "*" | expr | filter
 // union-element ::=  array-index | string-literal | array-slice
 // array-index ::= signed-integer
 // array-slice ::= start? ":" end? (":" stride?)?
 //
 // it's easier to accept a list of any both subscript-expressions and union-elements
-// and analyse the value list to see what it is (or if it's an illegal list)
+// and analyse the value list to see what it is
 func (p *parser) parseSubscript() (*Step, error) {
 	steps, err := p.parseValList()
 	if err != nil {
 		return nil, err
 	}
-	if len(steps) > 1 { // can't have subscript-expressions
+	return p.subscriptStep(steps)
+}
+
+// subscriptStep analyses a list of subscript-expressions and union-elements, returning the equivalent Step.
+// A union that includes a wildcard, filter or expression has a Step for each selector as its arguments,
+// in the order given, instead of the keys, indices and slices of a plain union.
+func (p *parser) subscriptStep(steps []*Step) (*Step, error) {
+	if len(steps) > 1 {
 		for _, step := range steps {
 			switch step.Op {
 			case OpWild, OpExp, OpFilter:
-				return nil, fmt.Errorf("%s cannot be in a union element list", step.Op)
+				return unionStep(steps), nil
 			default:
 				// ok
 			}
@@ -162,9 +195,24 @@ func (p *parser) parseSubscript() (*Step, error) {
 	}
 }
 
+// unionStep returns a union of selectors that are not all union-elements,
+// each of which is a Step that selects from the same value: Wild, Filter or Select.
+func unionStep(steps []*Step) *Step {
+	args := []Val{}
+	for _, step := range steps {
+		switch step.Op {
+		case OpWild, OpFilter:
+			args = append(args, step)
+		default:
+			// Exp(E) -> Select(E), and similarly for keys, indices and slices
+			args = append(args, &Step{OpSelect, step.Args})
+		}
+	}
+	return &Step{OpUnion, args}
+}
+
 // element ("," element)*
-// where element ::= union-element | subscript-expression,
-// where the latter cannot appear in a list.
+// where element ::= union-element | subscript-expression.
 // parseValList uses steps as a return value to tag the values with their internal type.
 func (p *parser) parseValList() ([]*Step, error) {
 	vals := []*Step{}
@@ -198,6 +246,9 @@ func (p *parser) parseVal() (*Step, error) {
 	case '*':
 		return &Step{OpWild, nil}, nil
 	case '(':
+		if p.dialect == RFC9535 {
+			return nil, p.rfcErr("script expression")
+		}
 		e, err := p.parseExpr()
 		if err != nil {
 			return nil, err
@@ -217,6 +268,17 @@ func (p *parser) parseVal() (*Step, error) {
 			return nil, err
 		}
 		return &Step{OpFilter, []Val{e}}, nil
+	case '?':
+		// RFC 9535 filter ::= "?" logical-expr
+		e, err := p.parseScriptExpr()
+		if err != nil {
+			return nil, err
+		}
+		e, err = rfcFilter(e)
+		if err != nil {
+			return nil, p.wrapErr(err)
+		}
+		return &Step{OpFilter, []Val{e}}, nil
 
 	// definitely union-element
 	case tokInt:
@@ -233,11 +295,15 @@ func (p *parser) parseVal() (*Step, error) {
 		return &Step{OpString, []Val{StringVal(lx.s())}}, nil
 	case tokID:
 		// treat same as string-literal
+		if p.dialect == RFC9535 {
+			return nil, p.rfcErr("unquoted name in brackets")
+		}
 		return &Step{OpID, []Val{NameVal(lx.s())}}, nil
 
 	default:
 		// illegal
-		return nil, fmt.Errorf("unexpected %v at %s", lx.tok, p.offset())
+		return nil, p.syntaxErr(fmt.Sprintf("unexpected %v at %s", lx.tok, p.offset()), lx.tok.String(),
+			tokens('*', '(', ':', tokFilter, tokInt, tokString, tokID)...)
 	}
 }
 
@@ -280,7 +346,8 @@ func (p *parser) parseSlice(start Val) (*Step, error) {
 		slice.Stride = e
 		return step, nil
 	default:
-		return nil, fmt.Errorf("unexpected token %s at %s", p.lookPath(), p.offset())
+		tok := p.lookPath()
+		return nil, p.syntaxErr(fmt.Sprintf("unexpected token %s at %s", tok, p.offset()), tok.String(), tokens(']', ',', ':')...)
 	}
 }
 
@@ -288,6 +355,9 @@ func (p *parser) parseSlice(start Val) (*Step, error) {
 func (p *parser) parseSliceVal() (Val, error) {
 	switch lx := p.lexPath(); lx.tok {
 	case '(':
+		if p.dialect == RFC9535 {
+			return nil, p.rfcErr("script expression")
+		}
 		e, err := p.parseExpr()
 		if err != nil {
 			return nil, err
@@ -296,7 +366,7 @@ func (p *parser) parseSliceVal() (Val, error) {
 	case tokInt:
 		return IntVal(lx.i()), nil
 	default:
-		return nil, fmt.Errorf("unexpected %v at %s", lx.tok, p.offset())
+		return nil, p.syntaxErr(fmt.Sprintf("unexpected %v at %s", lx.tok, p.offset()), lx.tok.String(), tokens(tokInt, '(')...)
 	}
 }
 
@@ -315,19 +385,31 @@ func (p *parser) parseMember() (Op, Val, error) {
 	//	// accept .'key' too
 	//	return OpString, NameVal(lx.s()), nil
 	case tokInt:
+		if p.dialect == RFC9535 {
+			return OpError, nil, p.rfcErr("index in dot notation")
+		}
 		return OpInt, IntVal(lx.i()), nil
 	case '(':
 		// expr ::= "(" script-expression ")"
+		if p.dialect == RFC9535 {
+			return OpError, nil, p.rfcErr("script expression")
+		}
 		e, err := p.parseExpr()
 		if err != nil {
 			return OpError, nil, err
 		}
 		return OpExp, e, nil
 	default:
-		return OpError, nil, fmt.Errorf("unexpected %v at %s", lx.tok, p.offset())
+		return OpError, nil, p.syntaxErr(fmt.Sprintf("unexpected %v at %s", lx.tok, p.offset()), lx.tok.String(),
+			tokens('*', tokID, tokInt, '(')...)
 	}
 }
 
+// rfcErr diagnoses a construction that is valid in the default grammar but not in RFC 9535.
+func (p *parser) rfcErr(what string) error {
+	return p.syntaxErr(fmt.Sprintf("%s not allowed by RFC 9535 at %s", what, p.offset()), "")
+}
+
 // parse the tail of expr or filter, expecting a closing ')'
 func (p *parser) parseExpr() (Expr, error) {
 	e, err := p.parseScriptExpr()
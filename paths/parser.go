@@ -9,18 +9,20 @@ type parser struct {
 	*lexer // source of tokens
 }
 
-// newParser initialises and returns a parser.
-func newParser(s string) *parser {
-	return &parser{newLexer(&rd{s: s})}
+// newParser initialises and returns a parser for the given dialect.
+func newParser(s string, dialect Dialect) *parser {
+	return &parser{newLexer(&rd{s: s}, dialect)}
 }
 
 func (p *parser) expect(lex func() lexeme, nt token) error {
 	lx := lex()
 	if lx.err != nil {
-		return fmt.Errorf("expected %q at %s, got %s", nt, p.offset(), lx.err)
+		err := p.syntaxErr(fmt.Sprintf("expected %q at %s, got %s", nt, p.offset(), lx.err), tokError.String(), nt.String())
+		err.Err = lx.err
+		return err
 	}
 	if lx.tok != nt {
-		return fmt.Errorf("expected %q at %s, got %v", nt, p.offset(), lx.tok)
+		return p.syntaxErr(fmt.Sprintf("expected %q at %s, got %v", nt, p.offset(), lx.tok), lx.tok.String(), nt.String())
 	}
 	return nil
 }
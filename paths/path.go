@@ -11,6 +11,8 @@ type Path []*Step
 
 // Step represents a single step in the path: an operation with zero or more parameters, each represented by a Val,
 // which is either a constant (signed integer, string, or member name) or an Expr to be evaluated.
+// A union (OpUnion or OpNestUnion) whose selectors include a wildcard, filter or expression instead
+// has a Step for each selector as its arguments: OpWild, OpFilter, or OpSelect for any other selector.
 type Step struct {
 	Op   Op    // Op is the action to take at this step. Not all Ops are valid Steps (eg, expression operators).
 	Args []Val // Zero or more arguments to the operation (eg, integer and string values, an identifier, a Slice or a filter or other Expr).
@@ -31,3 +33,8 @@ func (step *Step) String() string {
 	}
 	return sb.String()
 }
+
+// GoString returns the same text as String, for a Step that is the argument of another.
+func (step *Step) GoString() string {
+	return step.String()
+}
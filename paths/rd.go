@@ -55,10 +55,15 @@ func (r *rd) loc() Loc {
 	return Loc{r.line + 1, r.pos}
 }
 
+// offset returns a representation of errOffset for diagnostics.
 func (r *rd) offset() string {
-	o := r.pos
-	if o != 0 {
-		o--
+	return fmt.Sprintf("offset %d", r.errOffset())
+}
+
+// errOffset returns the offset of the input unit most recently read, where an error is typically detected.
+func (r *rd) errOffset() int {
+	if r.pos != 0 {
+		return r.pos - 1
 	}
-	return fmt.Sprintf("offset %d", o)
+	return 0
 }
@@ -0,0 +1,184 @@
+package paths
+
+import "fmt"
+
+// Dialect selects the grammar accepted by the parser.
+type Dialect int
+
+const (
+	// Parker is the default dialect, with the grammar described in jsonpath/syntax,
+	// following Parker's specification and the consensus of existing implementations.
+	Parker Dialect = iota
+
+	// RFC9535 is the grammar of RFC 9535: filters have the form ?logical-expr (with parentheses optional),
+	// there are no script (expr) selectors, dot-notation names cannot contain "-" or start with a digit,
+	// and filter expressions are limited to comparisons, logical operators, singular queries
+	// and calls of the RFC's function extensions (length, count, match, search and value).
+	RFC9535
+)
+
+var dialectNames = map[Dialect]string{
+	Parker:  "Parker",
+	RFC9535: "RFC 9535",
+}
+
+// String returns the name of the dialect.
+func (d Dialect) String() string {
+	return dialectNames[d]
+}
+
+// rfcType is the declared type of an expression in RFC 9535's type system for function extensions.
+type rfcType int
+
+const (
+	valueType   rfcType = iota // JSON value or Nothing
+	logicalType                // LogicalTrue or LogicalFalse
+	nodesType                  // nodelist
+)
+
+// rfcFunction gives the result and parameter types of one of RFC 9535's function extensions.
+type rfcFunction struct {
+	result rfcType
+	params []rfcType
+}
+
+var rfcFunctions = map[string]rfcFunction{
+	"length": {valueType, []rfcType{valueType}},
+	"count":  {valueType, []rfcType{nodesType}},
+	"match":  {logicalType, []rfcType{valueType, valueType}},
+	"search": {logicalType, []rfcType{valueType, valueType}},
+	"value":  {valueType, []rfcType{nodesType}},
+}
+
+// rfcFilter checks that filter expression e is a logical-expr as defined by RFC 9535,
+// returning e with each existence test made explicit (as OpExists), or an error.
+func rfcFilter(e Expr) (Expr, error) {
+	switch e.Opcode() {
+	case OpOr, OpAnd:
+		t := e.(*Inner)
+		for i, k := range t.Kids {
+			k, err := rfcFilter(k)
+			if err != nil {
+				return nil, err
+			}
+			t.Kids[i] = k
+		}
+		return t, nil
+	case OpNot:
+		t := e.(*Inner)
+		k, err := rfcFilter(t.Kids[0])
+		if err != nil {
+			return nil, err
+		}
+		t.Kids[0] = k
+		return t, nil
+	case OpEQ, OpNE, OpLT, OpLE, OpGT, OpGE:
+		for _, k := range e.(*Inner).Kids {
+			if err := rfcComparable(k); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	case OpCall:
+		fn, err := rfcCall(e.(*Inner))
+		if err != nil {
+			return nil, err
+		}
+		if fn.result != logicalType {
+			return nil, fmt.Errorf("result of %s is not a logical value", rfcCallee(e.(*Inner)))
+		}
+		return e, nil
+	default:
+		if isSingularQuery(e) || e.Opcode() == OpQuery {
+			// test for existence
+			return &Inner{OpExists, []Expr{e}}, nil
+		}
+		return nil, fmt.Errorf("%s is not allowed in an RFC 9535 filter", e.Opcode())
+	}
+}
+
+// rfcComparable checks that e is a comparable: a literal, a singular query, or a function with a value result.
+func rfcComparable(e Expr) error {
+	switch e.Opcode() {
+	case OpInt, OpReal, OpString, OpBool, OpNull:
+		return nil
+	case OpNeg:
+		// negative number literal
+		switch e.(*Inner).Kids[0].Opcode() {
+		case OpInt, OpReal:
+			return nil
+		}
+	case OpCall:
+		fn, err := rfcCall(e.(*Inner))
+		if err != nil {
+			return err
+		}
+		if fn.result != valueType {
+			return fmt.Errorf("result of %s cannot be compared", rfcCallee(e.(*Inner)))
+		}
+		return nil
+	default:
+		if isSingularQuery(e) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s cannot be compared in an RFC 9535 filter", e.Opcode())
+}
+
+// rfcCall checks a call of a function extension and the types of its arguments, returning the function's description.
+func rfcCall(call *Inner) (rfcFunction, error) {
+	name := rfcCallee(call)
+	fn, ok := rfcFunctions[name]
+	if !ok {
+		return fn, fmt.Errorf("unknown function %s", name)
+	}
+	args := call.Kids[1:]
+	if len(args) != len(fn.params) {
+		return fn, fmt.Errorf("%s: wrong argument count: need %d, got %d", name, len(fn.params), len(args))
+	}
+	for i, arg := range args {
+		switch fn.params[i] {
+		case valueType:
+			if err := rfcComparable(arg); err != nil {
+				return fn, fmt.Errorf("%s: argument %d: %w", name, i+1, err)
+			}
+		case nodesType:
+			if !isSingularQuery(arg) && arg.Opcode() != OpQuery {
+				return fn, fmt.Errorf("%s: argument %d must be a query", name, i+1)
+			}
+		}
+	}
+	return fn, nil
+}
+
+// rfcCallee returns the name of the function in a call.
+func rfcCallee(call *Inner) string {
+	return call.Kids[0].(*NameLeaf).Name
+}
+
+// isSingularQuery returns true if e is "@" or "$" followed by zero or more name or index selections.
+func isSingularQuery(e Expr) bool {
+	for {
+		switch e.Opcode() {
+		case OpCurrent, OpRoot:
+			return true
+		case OpDot:
+			e = e.(*Inner).Kids[0]
+		case OpIndex:
+			t := e.(*Inner)
+			switch sel := t.Kids[1]; sel.Opcode() {
+			case OpInt, OpString:
+				// ok
+			case OpNeg:
+				if sel.(*Inner).Kids[0].Opcode() != OpInt {
+					return false
+				}
+			default:
+				return false
+			}
+			e = t.Kids[0]
+		default:
+			return false
+		}
+	}
+}
@@ -29,6 +29,13 @@ const (
 	tokMatch                              // =~
 	tokIn                                 // "in"
 	tokNin                                // "nin"
+
+	tokCoalesce // ??
+	tokAnyof    // "anyof"
+	tokNoneof   // "noneof"
+	tokSubsetof // "subsetof"
+	tokSize     // "size"
+	tokEmpty    // "empty"
 )
 
 // hasVal returns true if token t has an associated value
@@ -60,6 +67,13 @@ var tokNames = map[token]string{
 	tokMatch:  "tokMatch",
 	tokIn:     "tokIn",
 	tokNin:    "tokNin",
+
+	tokCoalesce: "tokCoalesce",
+	tokAnyof:    "tokAnyof",
+	tokNoneof:   "tokNoneof",
+	tokSubsetof: "tokSubsetof",
+	tokSize:     "tokSize",
+	tokEmpty:    "tokEmpty",
 }
 
 // GoString returns the internal name of a token (for debugging)
@@ -89,6 +103,13 @@ var tokText = map[token]string{
 	tokMatch:  "=~",
 	tokIn:     "in",
 	tokNin:    "nin",
+
+	tokCoalesce: "??",
+	tokAnyof:    "anyof",
+	tokNoneof:   "noneof",
+	tokSubsetof: "subsetof",
+	tokSize:     "size",
+	tokEmpty:    "empty",
 }
 
 // String returns an readable form of a token for diagnostics
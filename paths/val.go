@@ -5,7 +5,7 @@ import (
 	"strings"
 )
 
-// Val is an int64, float64, string literal, name, bool?, *Slice or Expr as a value (see IntVal etc below), or nil as a missing value.
+// Val is an int64, float64, string literal, name, bool?, *Slice or Expr as a value (see IntVal etc below), a *Step as a selector in a union, or nil as a missing value.
 // It represents a parameter to a Path Step, or a value compiled into a Program from a leaf of an expression tree.
 // Types that satisfy Val correspond to elements in the JsonPath grammar (ie, a union type in its abstract syntax tree):
 //
@@ -0,0 +1,114 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type rfcTest struct {
+	path   string // RFC 9535 query
+	doc    string // JSON document
+	expect string // results as JSON, or "!" for a syntax error
+}
+
+var rfcTests = []rfcTest{
+	{`$.a`, `{"a": 1}`, `[1]`},
+	{`$[?@.a]`, `[{"a": false}, {"b": 1}, {"a": null}]`, `[{"a":false},{"a":null}]`},
+	{`$[?!@.a]`, `[{"a": false}, {"b": 1}]`, `[{"b":1}]`},
+	{`$[?(@.a == 1) || @.b]`, `[{"a": 1}, {"b": 0}, {"c": 2}]`, `[{"a":1},{"b":0}]`},
+	{`$[?@.a == '1']`, `[{"a": 1}, {"a": "1"}]`, `[{"a":"1"}]`},
+	{`$[?@.a == true]`, `[{"a": 1}, {"a": true}]`, `[{"a":true}]`},
+	{`$[?@.a < 2]`, `[{"a": 1}, {"a": "1"}, {"a": true}]`, `[{"a":1}]`},
+	{`$[?@.a <= null]`, `[{"a": null}, {"a": 0}]`, `[{"a":null}]`},
+	{`$[?@.a == @.b]`, `[{"a": 1}, {"c": 2}, {"a": [1, {"x": 2}], "b": [1, {"x": 2}]}]`, `[{"c":2},{"a":[1,{"x":2}],"b":[1,{"x":2}]}]`},
+	{`$[?@.length == 2]`, `[[1, 2], {"length": 2}]`, `[{"length":2}]`},
+	{`$[?length(@) == 2]`, `[[1, 2], {"length": 2}, "ab"]`, `[[1,2],"ab"]`},
+	{`$[?count(@.a) == 1]`, `[{"a": null}, {"b": 1}]`, `[{"a":null}]`},
+	{`$[?value(@.a) == 1]`, `[{"a": 1}, {"b": 1}]`, `[{"a":1}]`},
+	{`$[?match(@.a, 'a.c')]`, `[{"a": "abc"}, {"a": "xabc"}, {"a": "a\nc"}]`, `[{"a":"abc"}]`},
+	{`$[?search(@.a, '^b')]`, `[{"a": "abc"}, {"a": "a^bc"}]`, `[{"a":"a^bc"}]`},
+	{`$[?search(@.a, '\\d')]`, `[{"a": "a1"}]`, `[]`},
+	{`$[?match(@, '[a-c]+')]`, `["abc", "abd"]`, `["abc"]`},
+	{`$[?match(@, '(?i)abc')]`, `["abc", "ABC"]`, `[]`},
+	{`$[?search(@, '(?:b)')]`, `["abc"]`, `[]`},
+	{`$[?search(@, 'b+?')]`, `["abc"]`, `[]`},
+	{`$[?search(@, '\\Ab')]`, `["bc"]`, `[]`},
+	{`$..[?@.id == 2]`, `{"id": 2, "more": [{"id": 2}]}`, `[{"id":2,"more":[{"id":2}]},{"id":2}]`},
+	{`$[?@.a == -1]`, `[{"a": -1}]`, `[{"a":-1}]`},
+	{`$[(@.length-1)]`, `[1]`, `!`},
+	{`$.a-b`, `{}`, `!`},
+	{`$.2`, `{}`, `!`},
+	{`$[key]`, `{}`, `!`},
+	{`$[?@.a + 1 == 2]`, `[]`, `!`},
+	{`$[?@.a =~ /x/]`, `[]`, `!`},
+	{`$[?@.a in [1]]`, `[]`, `!`},
+	{`$[?length(@.a)]`, `[]`, `!`},
+	{`$[?match(@.a, 'x') == true]`, `[]`, `!`},
+	{`$[?unknown(@.a)]`, `[]`, `!`},
+	{`$[?count(@.a, 1) == 1]`, `[]`, `!`},
+	{`$.a^`, `{}`, `!`},
+	{`$.*~`, `{}`, `!`},
+	{`$[?@key == 'a']`, `{}`, `!`},
+	{`$[?@.*]`, `[[], [1], {}, {"a": 2}, 3]`, `[[1],{"a":2}]`},
+	{`$[?@..[?@.b]]`, `[{"a": [{"b": 1}]}, {"a": [3]}]`, `[{"a":[{"b":1}]}]`},
+	{`$[?count(@[*]) == 2]`, `[[1, 2], [1], {"a": 1, "b": 2}]`, `[[1,2],{"a":1,"b":2}]`},
+	{`$[?value(@..b) == 1]`, `[{"a": {"b": 1}}, {"b": 1, "c": {"b": 1}}]`, `[{"a":{"b":1}}]`},
+	{`$[?@.* == 1]`, `[]`, `!`},
+	{`$[?length(@.*) == 1]`, `[]`, `!`},
+	{`$[?@.a ?? @.b]`, `[]`, `!`},
+	{`$[?@.a ? @.b : @.c]`, `[]`, `!`},
+	{`$[0, 'a']`, `{"a": 1}`, `[1]`},
+	{`$[?@.a == 1e0]`, `[{"a": 1}, {"a": 2}]`, `[{"a":1}]`},
+	{`$[?@.a == 15E-1]`, `[{"a": 1.5}, {"a": 15}]`, `[{"a":1.5}]`},
+	{`$[?@.a == 0.5e+1]`, `[{"a": 5}, {"a": 0.5}]`, `[{"a":5}]`},
+	{`$[?@.a == -0]`, `[{"a": 0}, {"a": 1}]`, `[{"a":0}]`},
+	{`$[-1]`, `[1, 2, 3]`, `[3]`},
+	{`$[0]`, `[1, 2, 3]`, `[1]`},
+	{`$[01]`, `[]`, `!`},
+	{`$[-0]`, `[]`, `!`},
+	{`$[- 1]`, `[]`, `!`},
+	{`$[-0:]`, `[]`, `!`},
+	{`$[0:02]`, `[]`, `!`},
+	{`$[?@.a == 01]`, `[]`, `!`},
+	{`$[?@.a == 1.]`, `[]`, `!`},
+	{`$[?@.a == 1e]`, `[]`, `!`},
+	{`$[*, 0]`, `["a", "b"]`, `["a","b","a"]`},
+	{`$['b', *]`, `{"a": 1, "b": 2}`, `[2,1,2]`},
+	{`$[0, ?@.a]`, `[{"a": 1}, {"b": 2}]`, `[{"a":1},{"a":1}]`},
+	{`$[*][?@ > 1, 0]`, `[[1, 2], [3]]`, `[2,1,3,3]`},
+	{`$..[?@.j, 0]`, `{"o": {"j": 1, "k": 2}, "a": [5, 3, [{"j": 4}, {"k": 6}]]}`, `[{"j":1,"k":2},5,{"j":4},{"j":4}]`},
+}
+
+// TestRFC9535 checks the syntax and semantics of the RFC9535 dialect, where they differ from the default.
+func TestRFC9535(t *testing.T) {
+	for i, rt := range rfcTests {
+		jpath, err := Compile(rt.path, RFC9535)
+		if err != nil {
+			if rt.expect != "!" {
+				t.Errorf("rfc test %d: %s: unexpected error: %s", i, rt.path, err)
+			}
+			continue
+		}
+		if rt.expect == "!" {
+			t.Errorf("rfc test %d: %s: expected syntax error", i, rt.path)
+			continue
+		}
+		var doc interface{}
+		err = json.Unmarshal([]byte(rt.doc), &doc)
+		if err != nil {
+			t.Fatalf("rfc test %d: %s: bad document: %s", i, rt.doc, err)
+		}
+		vals, err := jpath.Eval(doc)
+		if err != nil {
+			t.Errorf("rfc test %d: %s: eval: %s", i, rt.path, err)
+			continue
+		}
+		got, err := json.Marshal(vals)
+		if err != nil {
+			t.Fatalf("rfc test %d: %s: cannot marshal result: %s", i, rt.path, err)
+		}
+		if string(got) != rt.expect {
+			t.Errorf("rfc test %d: %s: got %s, expected %s", i, rt.path, got, rt.expect)
+		}
+	}
+}
@@ -12,9 +12,10 @@ The two parts have different structures.
 Paths have the following grammar:
 
 	path ::= "$" step*
-	step ::= "." member | ".." member | "[" subscript "]" | ".." "[" subscript "]"
+	step ::= "." member | ".." member | "[" subscript "]" | ".." "[" subscript "]" | "^" | "~"
 	member ::= "*" | identifier | expr | signed-integer
-	subscript ::= subscript-expression | union-element ("," union-element)
+	subscript ::= selector ("," selector)*
+	selector ::= subscript-expression | union-element
 	subscript-expression ::= "*" | expr | filter
 	union-element ::=  array-index | string-literal | array-slice
 	array-index ::= signed-integer
@@ -25,7 +26,8 @@ Paths have the following grammar:
 	expr ::= "(" script-expression ")"
 	filter ::= "?(" script-expression ")"
 	step ::= ...  "[" subscript "]" ... | ".." "[" subscript "]"
-	subscript ::= subscript-expression | union-element ("," union-element)
+	subscript ::= selector ("," selector)*
+	selector ::= subscript-expression | union-element
 	subscript-expression ::= "*" | expr | filter
 	union-element ::=  array-index | string-literal | array-slice
 	array-index ::= signed-integer
@@ -38,16 +40,41 @@ Paths have the following grammar:
 Script expressions (filters and calculations) share the same syntax:
 
 	script-expression ::= e   // both filters and values share the same syntax
-	e ::= primary | e binary-op e
-	binary-op ::= "+" | "-" | "*" | "/" | "%" | "<" | ">" | ">=" | "<=" | "==" | "!=" | "=~" | "in" | "nin"  | "&&" | "||"
+	e ::= primary | e binary-op e | e "?" e ":" e
+	binary-op ::= "+" | "-" | "*" | "/" | "%" | "<" | ">" | ">=" | "<=" | "==" | "!=" | "=~" | "in" | "nin" | "anyof" | "noneof" | "subsetof" | "size" | "empty" | "&&" | "||" | "??"
 	unary-op ::= "-" | "!"
-	primary ::= primary1 ("(" e-list? ")" | "[" e "]" | "." identifier)*
+	primary ::= primary1 ("(" e-list? ")" | "[" e "]" | "." identifier | query-step)*
+	query-step ::= "." "*" | ".." member | ".." "[" subscript "]" | "[" subscript "]"   // after "@" or "$"
 	e-list ::= e ("," e)*
-	primary1 ::= identifier | integer | real | string | "/" re "/" | "@" | "$" | "(" e ")" | "[" e-list? "]" | unary-op primary1
+	primary1 ::= identifier | integer | real | string | "/" re "/" re-flags? | "@" | "@key" | "@path" | "$" | "(" e ")" | "[" e-list? "]" | "{" object-members? "}" | unary-op primary1
+	object-members ::= object-member ("," object-member)*
+	object-member ::= (string | identifier) ":" e
 	re ::= <regular expression of some style, with \/ escaping the delimiting "/">
+	re-flags ::= ("i" | "m" | "s")+   // ignore case, multi-line mode, "." matches newline
 	real ::= integer "." integer? ("e" [+-]? integer)?
 
-The semantics and built-in functions are generally those of https://danielaparker.github.io/JsonCons.Net/articles/JsonPath/Specification.html — a rare example of specifying JSONpath systematically instead of providing a few examples —  although the grammar above is more restrictive (eg, as regards the content of a union expression). Some of Parker's extensions (eg, the parent operator) are also not provided.
+The semantics and built-in functions are generally those of https://danielaparker.github.io/JsonCons.Net/articles/JsonPath/Specification.html — a rare example of specifying JSONpath systematically instead of providing a few examples —  although the grammar above is more restrictive. Parker's parent operator "^" is provided, as is "~", which selects the member name (or array index) of each value instead of the value, as in JSONPath-Plus; some of Parker's other extensions are not provided.
+A union can combine any selectors, as in $[*, 0] or $[?(@.a), 0]: each selector in turn selects from each value,
+so the results for each value are in the order of the selectors, and can include the same value more than once.
+In a filter, @key is the member name (or array index) of the value being tested, and @path is its location as an RFC 9535 normalized path.
+A query from "@" or "$" in an expression can use any of the steps of a path, including "..", wildcards, slices, unions and nested filters,
+as in $..book[?(@.tags[?(@ == 'new')])]. Its value is the list of values it selects: the test succeeds if the list is not empty,
+"==" compares the list with an array (or another such list), "in" searches it, and functions such as length and sum take it as an array.
+As in JavaScript, c ? a : b evaluates only one of a and b, depending on c, and a ?? b is b only if a is null or missing, unlike a || b, which is also b if a is false, 0 or "".
+Similarly, && and || evaluate their right operand only if the left one does not decide the result, so @.a && @.a.b =~ @.re
+does not fail when @.a is missing.
+As in Jayway's JsonPath, a anyof b is true if arrays a and b have a value in common, a noneof b if they have none, a subsetof b if every value of a is in b,
+a size n if array or string a has length n, and a empty true (or false) if a is (or is not) an empty array or string.
+Further functions can be made available to all paths by jsonpath.RegisterFunction, or to one path by the jsonpath.WithFunctions option to Compile.
+Compile rejects a call of an unknown function, or one with the wrong number of arguments.
+
+The RFC9535 option to jsonpath.Compile (or paths.ParsePathDialect with paths.RFC9535) instead accepts the grammar of RFC 9535.
+The main differences are that a filter is written "?" logical-expr, without the need for parentheses;
+there are no script (expr) selectors, in paths or slices; names in dot notation cannot contain "-" or be integers;
+and a filter expression can contain only comparisons, the logical operators "&&", "||" and "!",
+literals, singular queries (such as @.a[0].b), existence tests of other queries (such as @..b), and calls of the RFC's function extensions length, count, match, search and value.
+The comparisons follow RFC 9535 rather than JavaScript: values of different types are never equal, and only numbers and strings are ordered.
+The patterns of match and search are I-Regexps (RFC 9485).
 
 JSONpath expressions were originally described by https://goessner.net/articles/JsonPath/index.html by
 analogy with XPath for XML.